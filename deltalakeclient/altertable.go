@@ -0,0 +1,127 @@
+package deltalakeclient
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A change to a table's schema, see AlterTable.
+type AlterTableOp func(metadata *changeMetadataAction) error
+
+// Adds a column at the end of the table. Existing rows get column.Default for it, which must be set if the column
+// isn't nullable.
+func AddColumn(column Column) AlterTableOp {
+	return func(metadata *changeMetadataAction) error {
+		if columnIndex(metadata.Columns, column.Name) != -1 {
+			return fmt.Errorf("%w: duplicate column %s", errInvalidSchema, column.Name)
+		}
+		err := Schema{Columns: []Column{column}}.validate()
+		if err != nil {
+			return err
+		}
+		column.Default, err = validateValue(column, column.Default)
+		if err != nil {
+			return fmt.Errorf("%w: invalid default: %w", errInvalidSchema, err)
+		}
+
+		metadata.MaxColumnId++
+		column.Id = metadata.MaxColumnId
+		metadata.Columns = append(metadata.Columns, column)
+		return nil
+	}
+}
+
+// Removes a column. The values stay in existing dataobjects, but are never read again.
+func DropColumn(name string) AlterTableOp {
+	return func(metadata *changeMetadataAction) error {
+		i := columnIndex(metadata.Columns, name)
+		if i == -1 {
+			return fmt.Errorf("%w: no column %s", errInvalidSchema, name)
+		}
+		if len(metadata.Columns) == 1 {
+			return fmt.Errorf("%w: can't drop the last column", errInvalidSchema)
+		}
+		if slices.Contains(metadata.PrimaryKey, metadata.Columns[i].Id) {
+			return fmt.Errorf("%w: can't drop primary key column %s", errInvalidSchema, name)
+		}
+
+		metadata.Columns = slices.Delete(metadata.Columns, i, i+1)
+		return nil
+	}
+}
+
+func RenameColumn(from, to string) AlterTableOp {
+	return func(metadata *changeMetadataAction) error {
+		i := columnIndex(metadata.Columns, from)
+		if i == -1 {
+			return fmt.Errorf("%w: no column %s", errInvalidSchema, from)
+		}
+		if to == "" || columnIndex(metadata.Columns, to) != -1 {
+			return fmt.Errorf("%w: can't rename %s to %q", errInvalidSchema, from, to)
+		}
+
+		metadata.Columns[i].Name = to
+		return nil
+	}
+}
+
+// Changes the order of the columns, names must contain every column exactly once.
+func ReorderColumns(names []string) AlterTableOp {
+	return func(metadata *changeMetadataAction) error {
+		if len(names) != len(metadata.Columns) {
+			return fmt.Errorf("%w: expected %d columns to reorder, got %d", errInvalidSchema, len(metadata.Columns), len(names))
+		}
+
+		reordered := make([]Column, 0, len(names))
+		for _, name := range names {
+			i := columnIndex(metadata.Columns, name)
+			if i == -1 || columnIndex(reordered, name) != -1 {
+				return fmt.Errorf("%w: can't reorder with column %s", errInvalidSchema, name)
+			}
+			reordered = append(reordered, metadata.Columns[i])
+		}
+
+		metadata.Columns = reordered
+		return nil
+	}
+}
+
+// Applies the ops to the table's schema in order, either all of them or (if any are invalid) none. Rows already
+// written, flushed or not, are read with the new schema from then on.
+func (d *DeltaLakeClient) AlterTable(table string, ops ...AlterTableOp) error {
+	if d.tx == nil {
+		return errNoTx
+	}
+	if d.tx.readOnly {
+		return errReadOnlyTx
+	}
+
+	oldMetadata, ok := d.tx.tables[table]
+	if !ok {
+		return errNoTable
+	}
+
+	// Work on a copy, the old metadata is shared with older actions.
+	metadata := *oldMetadata
+	metadata.Columns = slices.Clone(oldMetadata.Columns)
+	for _, op := range ops {
+		err := op(&metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Unflushed rows are written with whatever the schema is at flush time, so bring them up to date now.
+	positions := columnPositions(columnIds(oldMetadata.Columns), metadata.Columns)
+	for i, row := range d.tx.unflushedData[table] {
+		if row != nil {
+			d.tx.unflushedData[table][i] = projectRow(row, positions, metadata.Columns)
+		}
+	}
+
+	d.tx.tables[table] = &metadata
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+		ChangeMetadata: &metadata,
+	})
+	return nil
+}
@@ -0,0 +1,63 @@
+package deltalakeclient
+
+import (
+	"encoding/json"
+	"hash/fnv"
+)
+
+// About a 1% false positive rate.
+const bloomFilterBitsPerValue = 10
+const bloomFilterHashes = 7
+
+// Returns a string that is the same for any two equal values of a column, e.g. an int in an unflushed untyped row and
+// the float64 it turns into once flushed. ok is false for values that can't be compared this way (NaNs).
+func valueKey(value any) (string, bool) {
+	bytes, err := json.Marshal(value)
+	return string(bytes), err == nil
+}
+
+func newBloomFilter(keys map[string]struct{}) []byte {
+	filter := make([]byte, (len(keys)*bloomFilterBitsPerValue+7)/8)
+	for key := range keys {
+		for _, bit := range bloomFilterBits(key, len(filter)*8) {
+			filter[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return filter
+}
+
+// False if the key definitely isn't in the filter.
+func bloomFilterContains(filter []byte, key string) bool {
+	if len(filter) == 0 {
+		return false
+	}
+	for _, bit := range bloomFilterBits(key, len(filter)*8) {
+		if filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func bloomFilterBits(key string, size int) [bloomFilterHashes]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	// Deriving them all from two hashes (i.e. h1 + i*h2) doesn't spread them out well in filters this small.
+	var bits [bloomFilterHashes]uint64
+	for i := range bits {
+		bits[i] = mix64(sum+uint64(i)*0x9e3779b97f4a7c15) % uint64(size)
+	}
+	return bits
+}
+
+// FNV's bits don't change much between similar keys, so mix them up (MurmurHash3's finaliser).
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
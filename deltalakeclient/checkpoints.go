@@ -0,0 +1,85 @@
+package deltalakeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rptynan/delta-lake/utils"
+)
+
+// A snapshot of the whole log up to and including transaction Id, i.e. what NewTx would have reconstructed by reading
+// every log file up to that point.
+type checkpointT struct {
+	Id      int
+	Actions map[string][]Action
+	Tables  map[string]*changeMetadataAction
+}
+
+func checkpointFilename(txId int) string {
+	return fmt.Sprintf("_checkpoint_%020d", txId)
+}
+
+// Returns the latest checkpoint at or before transaction maxId (or the latest overall if maxId is negative), or nil if
+// there are no (readable) checkpoints.
+func (d *DeltaLakeClient) readLatestCheckpoint(ctx context.Context, maxId int) (*checkpointT, error) {
+	checkpointFilenames, err := d.os.ListPrefixOrdered(ctx, "_checkpoint_")
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first. If one is corrupt we can fall back to an older one, at worst replaying the whole log.
+	for i := len(checkpointFilenames) - 1; i >= 0; i-- {
+		if maxId >= 0 && checkpointFilenames[i] > checkpointFilename(maxId) {
+			continue
+		}
+
+		bytes, err := d.os.Read(ctx, checkpointFilenames[i])
+		if err != nil {
+			return nil, err
+		}
+
+		var checkpoint checkpointT
+		err = json.Unmarshal(bytes, &checkpoint)
+		if err != nil {
+			utils.Debug("skipping unreadable checkpoint", checkpointFilenames[i], err)
+			continue
+		}
+		return &checkpoint, nil
+	}
+
+	return nil, nil
+}
+
+// Called once tx has been committed. The commit has already happened, so failing to write the checkpoint isn't an
+// error for the caller. Nobody retries it though, the next one is only written `interval` transactions later, and until
+// then new transactions replay more of the log from the checkpoint before.
+func (d *DeltaLakeClient) maybeWriteCheckpoint(ctx context.Context, tx *transaction) {
+	if d.checkpointInterval <= 0 || (tx.Id+1)%d.checkpointInterval != 0 {
+		return
+	}
+
+	checkpoint := checkpointT{
+		Id:      tx.Id,
+		Actions: map[string][]Action{},
+		Tables:  tx.tables,
+	}
+	for table, actions := range tx.previousActions {
+		checkpoint.Actions[table] = append(checkpoint.Actions[table], actions...)
+	}
+	for table, actions := range tx.Actions {
+		for _, action := range actions {
+			if action.ChangeMetadata == nil {
+				checkpoint.Actions[table] = append(checkpoint.Actions[table], action)
+			}
+		}
+	}
+
+	bytes, err := json.Marshal(checkpoint)
+	if err == nil {
+		err = d.os.PutIfAbsent(ctx, checkpointFilename(tx.Id), bytes)
+	}
+	if err != nil {
+		utils.Debug("could not write checkpoint", tx.Id, err)
+	}
+}
@@ -0,0 +1,85 @@
+package deltalakeclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// How a table's dataobjects are serialised, set with Schema.Codec.
+type Codec string
+
+const (
+	// Each dataobject is one JSON document. Tables created before codecs existed use this.
+	CodecJSON Codec = "json"
+	// A binary format that stores each column separately, with an encoding suited to its type, and compresses it. Values
+	// of typed columns keep their exact Go types. This is the default for new tables.
+	CodecColumnar Codec = "columnar"
+	// Apache Parquet, so other tools can read the dataobjects. Column ids are stored as Parquet field ids.
+	CodecParquet Codec = "parquet"
+)
+
+type dataobjectCodec interface {
+	// `columns` is the table's current schema, which the rows are in.
+	encode(do *dataobjectT, columns []Column) ([]byte, error)
+	// Returns the dataobject with its rows still in the columns it was written with (see dataobjectT.Columns). Values
+	// are converted to the Go types of `columns`, the table's current schema, where the codec needs it.
+	decode(data []byte, columns []Column) (*dataobjectT, error)
+}
+
+var codecs = map[Codec]dataobjectCodec{
+	CodecJSON:     jsonCodec{},
+	CodecColumnar: columnarCodec{},
+	CodecParquet:  parquetCodec{},
+}
+
+func codecFor(codec Codec) (dataobjectCodec, error) {
+	c, ok := codecs[codec]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown codec %q", errInvalidSchema, codec)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) encode(do *dataobjectT, columns []Column) ([]byte, error) {
+	return json.Marshal(do)
+}
+
+func (jsonCodec) decode(data []byte, columns []Column) (*dataobjectT, error) {
+	// Decode numbers as json.Number so typed columns get them back exactly, and convert everything back into the Go
+	// types of the columns.
+	var stored struct {
+		dataobjectT
+		// Dataobjects used to always have DATAOBJECT_SIZE rows, padded with nulls, and this many of them were real.
+		Len *int
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+	do := stored.dataobjectT
+	if stored.Len != nil {
+		if *stored.Len < 0 || *stored.Len > len(do.Data) {
+			return nil, errCorruptDataobject
+		}
+		do.Data = do.Data[:*stored.Len]
+	}
+
+	// Values of columns that have since been dropped are left alone, they won't be read.
+	positions := columnPositions(do.Columns, columns)
+	for _, row := range do.Data {
+		for j, position := range positions {
+			if position >= 0 && position < len(row) {
+				row[position], err = decodeJSONValue(columns[j], row[position])
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return &do, nil
+}
@@ -0,0 +1,340 @@
+package deltalakeclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"math/big"
+	"time"
+)
+
+// The columnar codec (loosely modelled on Parquet) writes:
+//
+//	"DLC1"
+//	uvarint number of rows
+//	uvarint number of columns
+//	for each column:
+//	    uvarint column id
+//	    uvarint length, column type
+//	    byte compression (0 none, 1 flate)
+//	    uvarint length, chunk
+//
+// where each chunk (once decompressed) is the column's nulls (uvarint length, then a bitmap, see encodeBitmap)
+// followed by its non-null values, encoded depending on the column's type (see appendColumnValues).
+const columnarMagic = "DLC1"
+
+const (
+	compressionNone  byte = 0
+	compressionFlate byte = 1
+)
+
+type columnarCodec struct{}
+
+func (columnarCodec) encode(do *dataobjectT, columns []Column) ([]byte, error) {
+	encoded := []byte(columnarMagic)
+	encoded = binary.AppendUvarint(encoded, uint64(len(do.Data)))
+	encoded = binary.AppendUvarint(encoded, uint64(len(columns)))
+
+	for i, column := range columns {
+		nulls := make([]bool, len(do.Data))
+		var values []any
+		for j, row := range do.Data {
+			if row[i] == nil {
+				nulls[j] = true
+			} else {
+				values = append(values, row[i])
+			}
+		}
+
+		chunk := appendBytes(nil, encodeBitmap(nulls))
+		chunk, err := appendColumnValues(chunk, column, values)
+		if err != nil {
+			return nil, err
+		}
+		compression, chunk, err := compressChunk(chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = binary.AppendUvarint(encoded, uint64(column.Id))
+		encoded = appendBytes(encoded, []byte(column.Type))
+		encoded = append(encoded, compression)
+		encoded = appendBytes(encoded, chunk)
+	}
+	return encoded, nil
+}
+
+// Values are stored with their own column's type, so unlike JSON this doesn't need to know the current schema.
+func (columnarCodec) decode(data []byte, columns []Column) (*dataobjectT, error) {
+	if !bytes.HasPrefix(data, []byte(columnarMagic)) {
+		return nil, errCorruptDataobject
+	}
+	r := &columnarReader{b: data[len(columnarMagic):]}
+
+	rows := r.uvarint()
+	numColumns := r.uvarint()
+	// Rows with no columns would take up nothing in the file, so they'd be an easy way to claim any number of rows.
+	if r.err != nil || numColumns > uint64(len(r.b)) || (numColumns == 0 && rows > 0) {
+		return nil, errCorruptDataobject
+	}
+
+	do := &dataobjectT{}
+	for i := range int(numColumns) {
+		id := r.uvarint()
+		columnType := ColumnType(r.bytes())
+		compression := r.byte()
+		chunk := r.bytes()
+		if r.err != nil {
+			return nil, r.err
+		}
+		do.Columns = append(do.Columns, int(id))
+
+		chunk, err := decompressChunk(compression, chunk)
+		if err != nil {
+			return nil, err
+		}
+		chunkReader := &columnarReader{b: chunk}
+		encodedNulls := chunkReader.bytes()
+		// Only allocate the rows once the first column agrees on how many there are, so a corrupt row count can't have
+		// us allocate all the memory.
+		if !bitmapHasLength(encodedNulls, rows) {
+			return nil, errCorruptDataobject
+		}
+		if i == 0 {
+			do.Data = make([][]any, rows)
+			for j := range do.Data {
+				do.Data[j] = make([]any, numColumns)
+			}
+		}
+		nulls, ok := decodeBitmap(encodedNulls, len(do.Data))
+		if !ok {
+			return nil, errCorruptDataobject
+		}
+		nonNull := 0
+		for _, null := range nulls {
+			if !null {
+				nonNull++
+			}
+		}
+
+		values, err := readColumnValues(chunkReader, columnType, nonNull)
+		if err != nil {
+			return nil, err
+		}
+		for j, null := range nulls {
+			if !null {
+				do.Data[j][i] = values[0]
+				values = values[1:]
+			}
+		}
+	}
+	return do, nil
+}
+
+func appendBytes(b []byte, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// Integers (and times) are stored as the difference from the previous value, which is small for sorted or clustered
+// columns (e.g. ids, or timestamps of when rows were written) and so takes few bytes as a varint.
+func appendColumnValues(b []byte, column Column, values []any) ([]byte, error) {
+	var previous int64
+	var bools []bool
+	for _, value := range values {
+		ok := true
+		switch column.Type {
+		case TypeAny:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			b = appendBytes(b, encoded)
+		case TypeInt64:
+			var i int64
+			i, ok = value.(int64)
+			b = binary.AppendVarint(b, i-previous)
+			previous = i
+		case TypeFloat64:
+			var f float64
+			f, ok = value.(float64)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+		case TypeString:
+			var s string
+			s, ok = value.(string)
+			b = appendBytes(b, []byte(s))
+		case TypeBytes:
+			var bs []byte
+			bs, ok = value.([]byte)
+			b = appendBytes(b, bs)
+		case TypeBool:
+			var v bool
+			v, ok = value.(bool)
+			bools = append(bools, v)
+		case TypeTimestamp, TypeDate:
+			var t time.Time
+			t, ok = value.(time.Time)
+			b = binary.AppendVarint(b, t.Unix()-previous)
+			b = binary.AppendUvarint(b, uint64(t.Nanosecond()))
+			previous = t.Unix()
+		case TypeDecimal:
+			var r *big.Rat
+			r, ok = value.(*big.Rat)
+			if ok {
+				b = appendBytes(b, []byte(r.RatString()))
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, errTypeMismatch
+		}
+	}
+
+	if column.Type == TypeBool {
+		b = appendBytes(b, encodeBitmap(bools))
+	}
+	return b, nil
+}
+
+func readColumnValues(r *columnarReader, columnType ColumnType, n int) ([]any, error) {
+	values := make([]any, n)
+	var previous int64
+	var bools []bool
+	if columnType == TypeBool {
+		var ok bool
+		bools, ok = decodeBitmap(r.bytes(), n)
+		if !ok {
+			return nil, errCorruptDataobject
+		}
+	}
+
+	for i := range values {
+		switch columnType {
+		case TypeAny:
+			decoder := json.NewDecoder(bytes.NewReader(r.bytes()))
+			decoder.UseNumber()
+			var value any
+			if decoder.Decode(&value) != nil {
+				return nil, errCorruptDataobject
+			}
+			values[i] = fromJSONNumbers(value)
+		case TypeInt64:
+			previous += r.varint()
+			values[i] = previous
+		case TypeFloat64:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(r.fixed(8)))
+		case TypeString:
+			values[i] = string(r.bytes())
+		case TypeBytes:
+			values[i] = bytes.Clone(r.bytes())
+		case TypeBool:
+			values[i] = bools[i]
+		case TypeTimestamp, TypeDate:
+			previous += r.varint()
+			values[i] = time.Unix(previous, int64(r.uvarint())).UTC()
+		case TypeDecimal:
+			value, ok := new(big.Rat).SetString(string(r.bytes()))
+			if !ok {
+				return nil, errCorruptDataobject
+			}
+			values[i] = value
+		default:
+			return nil, errCorruptDataobject
+		}
+	}
+	return values, r.err
+}
+
+// Compresses the chunk, unless that doesn't make it smaller (e.g. a few rows with random values).
+func compressChunk(chunk []byte) (byte, []byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return 0, nil, err
+	}
+	_, err = w.Write(chunk)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if buf.Len() >= len(chunk) {
+		return compressionNone, chunk, nil
+	}
+	return compressionFlate, buf.Bytes(), nil
+}
+
+func decompressChunk(compression byte, chunk []byte) ([]byte, error) {
+	switch compression {
+	case compressionNone:
+		return chunk, nil
+	case compressionFlate:
+		return io.ReadAll(flate.NewReader(bytes.NewReader(chunk)))
+	default:
+		return nil, errCorruptDataobject
+	}
+}
+
+// Reads the primitives the columnar format is made of. Once anything fails to read, everything after returns zero
+// values and err is set.
+type columnarReader struct {
+	b   []byte
+	err error
+}
+
+func (r *columnarReader) fail() {
+	r.b = nil
+	r.err = errCorruptDataobject
+}
+
+func (r *columnarReader) uvarint() uint64 {
+	value, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return value
+}
+
+func (r *columnarReader) varint() int64 {
+	value, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return value
+}
+
+func (r *columnarReader) byte() byte {
+	b := r.fixed(1)
+	return b[0]
+}
+
+func (r *columnarReader) fixed(n int) []byte {
+	if len(r.b) < n {
+		r.fail()
+		return make([]byte, n)
+	}
+	value := r.b[:n]
+	r.b = r.b[n:]
+	return value
+}
+
+// Length prefixed bytes, see appendBytes.
+func (r *columnarReader) bytes() []byte {
+	n := r.uvarint()
+	if n > uint64(len(r.b)) {
+		r.fail()
+		return nil
+	}
+	return r.fixed(int(n))
+}
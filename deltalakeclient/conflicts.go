@@ -0,0 +1,88 @@
+package deltalakeclient
+
+import (
+	"context"
+	"fmt"
+)
+
+// Reads the transactions committed since tx started. If none of them conflict with tx, tx is moved on top of them
+// (as if it had started after they committed) so it can try to commit again.
+func (d *DeltaLakeClient) rebase(ctx context.Context, tx *transaction) error {
+	txLogFilenames, err := d.os.ListPrefixOrdered(ctx, "_log_")
+	if err != nil {
+		return err
+	}
+
+	startId := tx.Id
+	for _, txLogFilename := range txLogFilenames {
+		if txLogFilename < logFilename(startId) {
+			continue
+		}
+
+		winner, err := d.readLog(ctx, txLogFilename)
+		if err != nil {
+			return err
+		}
+		err = tx.checkConflict(winner)
+		if err != nil {
+			return err
+		}
+		tx.replay(winner)
+	}
+
+	// Our dataobjects need to be ordered after the ones the winners wrote. Rewritten (copy-on-write) dataobjects keep
+	// the TxId of what they replaced, unless that was written in this transaction too.
+	for _, actions := range tx.Actions {
+		for _, action := range actions {
+			if action.AddDataobject != nil && action.AddDataobject.TxId == startId {
+				action.AddDataobject.TxId = tx.Id
+			}
+			if action.DeleteDataobject != nil && action.DeleteDataobject.TxId == startId {
+				action.DeleteDataobject.TxId = tx.Id
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns an error if winner, which committed after tx started, changed anything tx depended on or also changed.
+// Blind appends to the same table, and any changes to unrelated tables, don't conflict.
+func (tx *transaction) checkConflict(winner *transaction) error {
+	for table, winnerActions := range winner.Actions {
+		_, read := tx.readTables[table]
+		ours := tx.Actions[table]
+
+		deletedByUs := map[string]struct{}{}
+		for _, action := range ours {
+			if action.DeleteDataobject != nil {
+				deletedByUs[action.DeleteDataobject.Name] = struct{}{}
+			}
+		}
+
+		for _, action := range winnerActions {
+			switch {
+			case action.ChangeMetadata != nil:
+				if read || len(ours) > 0 {
+					return fmt.Errorf("%w: table %s was changed by transaction %d", errConflict, table, winner.Id)
+				}
+			case action.AddDataobject != nil:
+				if read {
+					return fmt.Errorf("%w: table %s was written to by transaction %d", errConflict, table, winner.Id)
+				}
+			case action.DeleteDataobject != nil:
+				if _, ok := deletedByUs[action.DeleteDataobject.Name]; ok || read {
+					return fmt.Errorf("%w: table %s was deleted from by transaction %d", errConflict, table, winner.Id)
+				}
+			case action.DeletionVector != nil:
+				// Deletion vectors replace the previous one for the dataobject, so two transactions adding one to the same
+				// dataobject would lose one's deletes (deleting always reads the table though, so that's covered). And if we
+				// rewrote the dataobject (e.g. Optimize, which doesn't read), the rewrite would bring the rows back.
+				if _, ok := deletedByUs[action.DeletionVector.Name]; ok || read {
+					return fmt.Errorf("%w: table %s was deleted from by transaction %d", errConflict, table, winner.Id)
+				}
+			}
+		}
+	}
+	return nil
+}
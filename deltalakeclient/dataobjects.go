@@ -1,94 +1,171 @@
 package deltalakeclient
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"sort"
 
 	"github.com/google/uuid"
 )
 
+func dataobjectFilename(table, name string) string {
+	return fmt.Sprintf("_table_%s_%s", table, name)
+}
+
 type dataobjectT struct {
 	Table string
 	Name  string
-	Data  [DATAOBJECT_SIZE][]any
-	Len   int
+	Data  [][]any
+	// Ids of the columns the rows were written with, in order. Missing for dataobjects written before columns had
+	// ids, whose rows just had the table's columns in order.
+	Columns []int
+
+	// How big it was in storage, when it's been read.
+	size int
+}
+
+// A dataobject in the current version of a table.
+type extantDataobject struct {
+	*dataobjectActionT
+	// The latest deletion vector for the dataobject, nil if none of its rows have been deleted that way.
+	deletionVector *deletionVectorAction
+}
+
+// Reads a dataobject, with its rows in the current schema of the table. Rows deleted by its deletion vector are nil.
+func (d *DeltaLakeClient) readDataobject(
+	ctx context.Context, table string, object extantDataobject,
+) (*dataobjectT, error) {
+	return d.readDataobjectAs(ctx, d.tx.tables[table], object)
 }
 
-func (d *DeltaLakeClient) readDataobject(table, name string) (*dataobjectT, error) {
-	bytes, err := d.os.Read(fmt.Sprintf("_table_%s_%s", table, name))
+// Like readDataobject, but in the schema given rather than the transaction's current one for the table. Doesn't touch
+// the transaction, so prefetching scans can use it from other goroutines.
+func (d *DeltaLakeClient) readDataobjectAs(
+	ctx context.Context, metadata *changeMetadataAction, object extantDataobject,
+) (*dataobjectT, error) {
+	data, err := d.os.Read(ctx, object.filename())
 	if err != nil {
 		return nil, err
 	}
+	d.metrics.dataobjectsRead.Add(1)
 
-	var do dataobjectT
-	err = json.Unmarshal(bytes, &do)
-	return &do, err
-}
+	codec, err := codecFor(metadata.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if object.Path != "" {
+		// Registered files are always Parquet, and we worked out which columns are which when they were registered.
+		codec = parquetCodec{columnIds: object.Columns}
+	}
+	do, err := codec.decode(data, metadata.Columns)
+	if err != nil {
+		return nil, err
+	}
+	do.Table, do.Name, do.size = object.Table, object.Name, len(data)
 
-// Writes the rows provided (filtering out nils) and returns the AddDataobject action for the created file. Callers are
-// responsible for putting that action into the transaction.
-// For most purposes, txId can be the current transaction ID (i.e. d.tx.Id), however in some cases (such as
-// copy-on-write, the caller provides a different value).
-func (d *DeltaLakeClient) writeDataObject(table string, rows *[DATAOBJECT_SIZE][]any, txId int) (Action, error) {
-	// We filter here because of deletes using nils as tombstones in the unflushed data.
-	var filteredRows [DATAOBJECT_SIZE][]any
-	filteredRowsPointer := 0
-	for _, row := range rows {
-		if row != nil {
-			filteredRows[filteredRowsPointer] = row
-			filteredRowsPointer++
+	// The schema may have changed since this was written, so rearrange the rows to match the current one.
+	positions := columnPositions(do.Columns, metadata.Columns)
+	for i, row := range do.Data {
+		do.Data[i] = projectRow(row, positions, metadata.Columns)
+	}
+	do.Columns = columnIds(metadata.Columns)
+
+	if object.deletionVector != nil {
+		deleted, ok := decodeBitmap(object.deletionVector.Deleted, len(do.Data))
+		if !ok {
+			return nil, errCorruptDeletionVector
+		}
+		for i := range deleted {
+			if deleted[i] {
+				do.Data[i] = nil
+			}
 		}
 	}
+	return do, nil
+}
 
+// Writes the rows provided and returns the AddDataobject action for the created file. Callers are
+// responsible for putting that action into the transaction.
+// For most purposes, txId can be the current transaction ID (i.e. d.tx.Id), however in some cases (such as
+// copy-on-write, the caller provides a different value). Same for seq, see dataobjectActionT.
+func (d *DeltaLakeClient) writeDataObject(
+	ctx context.Context, table string, rows [][]any, txId int, seq int,
+) (Action, error) {
+	metadata := d.tx.tables[table]
 	newDataobject := dataobjectT{
 		Table: table,
 		Name:  uuid.New().String(),
-		Data:  filteredRows,
-		Len:   filteredRowsPointer,
+		Data:  rows,
+		// Rows are always in the current schema by the time they're written.
+		Columns: columnIds(metadata.Columns),
 	}
 
-	serialisedbytes, err := json.Marshal(newDataobject)
+	codec, err := codecFor(metadata.Codec)
+	if err != nil {
+		return Action{}, err
+	}
+	serialisedbytes, err := codec.encode(&newDataobject, metadata.Columns)
 	if err != nil {
 		return Action{}, err
 	}
 
-	filename := fmt.Sprintf("_table_%s_%s", table, newDataobject.Name)
-	err = d.os.PutIfAbsent(filename, serialisedbytes)
+	err = d.os.PutIfAbsent(ctx, dataobjectFilename(table, newDataobject.Name), serialisedbytes)
 	if err != nil {
 		return Action{}, err
 	}
 
 	return Action{
 		AddDataobject: &dataobjectActionT{
-			Name: newDataobject.Name, Table: table, TxId: txId,
+			Name: newDataobject.Name, Table: table, TxId: txId, Seq: seq,
+			Stats: computeStats(metadata.Columns, rows),
 		},
 	}, nil
 }
 
-// For a given table, lists all dataobjects that have not been deleted.
+// Whether the dataobject can be skipped, because mightMatch (if set) says it has no rows we're looking for.
+func (d *DeltaLakeClient) pruned(object extantDataobject, mightMatch func(stats *dataobjectStats) bool) bool {
+	// Dataobjects written before we kept stats have to be read.
+	if mightMatch == nil || object.Stats == nil || mightMatch(object.Stats) {
+		return false
+	}
+	d.metrics.dataobjectsPruned.Add(1)
+	return true
+}
+
+// For a given table, lists all dataobjects that have not been deleted, along with their deletion vectors.
 // This will return the dataobjects in chronological order.
-// The returned slice contains dataobjectActions for all adds that have not been deleted.
-func (d *DeltaLakeClient) listExtantDataobjects(table string) []*dataobjectActionT {
+func (d *DeltaLakeClient) listExtantDataobjects(table string) []extantDataobject {
 	allActions := append(d.tx.previousActions[table], d.tx.Actions[table]...)
 
 	deletedDataobjectsSet := make(map[string]struct{})
+	deletionVectors := make(map[string]*deletionVectorAction)
 	for _, action := range allActions {
 		if action.DeleteDataobject != nil {
 			deletedDataobjectsSet[action.DeleteDataobject.Name] = struct{}{}
 		}
+		if action.DeletionVector != nil {
+			// Later ones replace earlier ones.
+			deletionVectors[action.DeletionVector.Name] = action.DeletionVector
+		}
 	}
 
-	var extantDataobjects []*dataobjectActionT
+	var extantDataobjects []extantDataobject
 	for _, action := range allActions {
 		if action.AddDataobject != nil {
 			if _, deleted := deletedDataobjectsSet[action.AddDataobject.Name]; !deleted {
-				extantDataobjects = append(extantDataobjects, action.AddDataobject)
+				extantDataobjects = append(extantDataobjects, extantDataobject{
+					dataobjectActionT: action.AddDataobject,
+					deletionVector:    deletionVectors[action.AddDataobject.Name],
+				})
 			}
 		}
 	}
 
-	// Sort by the TxId. See note on that field for why.
-	sort.Slice(extantDataobjects, func(i, j int) bool { return extantDataobjects[i].TxId < extantDataobjects[j].TxId })
+	// Sort by the TxId and Seq. See note on those fields for why. Logs written before Seq existed have it as 0 for all
+	// their dataobjects, so keep those in the order they were added.
+	sort.SliceStable(extantDataobjects, func(i, j int) bool {
+		a, b := extantDataobjects[i], extantDataobjects[j]
+		return a.TxId < b.TxId || (a.TxId == b.TxId && a.Seq < b.Seq)
+	})
 	return extantDataobjects
 }
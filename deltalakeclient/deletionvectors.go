@@ -0,0 +1,66 @@
+package deltalakeclient
+
+import (
+	"encoding/binary"
+)
+
+// Marks rows of a dataobject as deleted without rewriting it. Each one holds every deleted row of the dataobject (not
+// just the ones deleted by this transaction), so only the latest one for a dataobject matters.
+type deletionVectorAction struct {
+	Name  string
+	Table string
+	// Positions of the deleted rows, see encodeBitmap.
+	Deleted []byte
+}
+
+// Bitmaps are run-length encoded: alternating counts of unset and set bits (starting with unset) as uvarints. E.g.
+// bits 2, 3 and 7 set out of 10 is 2,2,3,1,2. Deletes tend to be clustered (ranges, or everything from one
+// transaction), as do nulls, so this is usually a few bytes.
+func encodeBitmap(bits []bool) []byte {
+	var encoded []byte
+	run := 0
+	current := false
+	for _, b := range bits {
+		if b != current {
+			encoded = binary.AppendUvarint(encoded, uint64(run))
+			run = 0
+			current = b
+		}
+		run++
+	}
+	return binary.AppendUvarint(encoded, uint64(run))
+}
+
+// Whether the bitmap is a valid encoding of exactly n bits, without decoding it.
+func bitmapHasLength(encoded []byte, n uint64) bool {
+	var total uint64
+	for len(encoded) > 0 {
+		run, read := binary.Uvarint(encoded)
+		if read <= 0 || run > n-total {
+			return false
+		}
+		total += run
+		encoded = encoded[read:]
+	}
+	return total == n
+}
+
+// Returns the n bits of the bitmap, or false if it isn't a valid encoding of exactly that many.
+func decodeBitmap(encoded []byte, n int) ([]bool, bool) {
+	if !bitmapHasLength(encoded, uint64(n)) {
+		return nil, false
+	}
+	bits := make([]bool, n)
+	position := 0
+	current := false
+	for len(encoded) > 0 {
+		run, read := binary.Uvarint(encoded)
+		for i := position; i < position+int(run); i++ {
+			bits[i] = current
+		}
+		position += int(run)
+		encoded = encoded[read:]
+		current = !current
+	}
+	return bits, true
+}
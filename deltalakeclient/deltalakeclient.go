@@ -2,30 +2,126 @@ package deltalakeclient
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/rptynan/delta-lake/objectstorage"
 )
 
-// How many rows to accumulate before flushing
-// TODO make this configurable, currently set to 10 for easy debugging
+// How many rows to accumulate before flushing by default, see WithMaxDataobjectRows.
+// TODO currently set to 10 for easy debugging
 // const DATAOBJECT_SIZE int = 64 * 1024
 const DATAOBJECT_SIZE int = 10
 
+// Write a checkpoint every this many transactions by default.
+const DEFAULT_CHECKPOINT_INTERVAL int = 10
+
+// How many times to retry a commit after losing a race with a non-conflicting transaction by default.
+const DEFAULT_COMMIT_RETRIES int = 5
+
 type DeltaLakeClient struct {
 	os objectstorage.ObjectStorage
 	// Current transaction, if any. Only one transaction per client at a time. All
-	// reads and writes must be within a transaction.
+	// reads and writes must be within a transaction. See Begin for having more than one.
 	tx *transaction
+
+	// See WithCheckpointInterval.
+	checkpointInterval int
+	// See WithCommitRetries.
+	commitRetries int
+	// See WithMaxDataobjectRows and WithMaxDataobjectBytes.
+	maxDataobjectRows  int
+	maxDataobjectBytes int
+
+	// Shared with the Txs from Begin, which are copies of the client.
+	metrics   *clientMetrics
+	snapshots *snapshotCache
+}
+
+// Counters of the work done by a client, since it was created.
+type Metrics struct {
+	// Dataobjects read by scans, deletes, updates and Optimize.
+	DataobjectsRead int
+	// Dataobjects that weren't read because their stats showed they had no rows we were looking for.
+	DataobjectsPruned int
+}
+
+type clientMetrics struct {
+	dataobjectsRead   atomic.Int64
+	dataobjectsPruned atomic.Int64
+}
+
+func (d *DeltaLakeClient) Metrics() Metrics {
+	return Metrics{
+		DataobjectsRead:   int(d.metrics.dataobjectsRead.Load()),
+		DataobjectsPruned: int(d.metrics.dataobjectsPruned.Load()),
+	}
+}
+
+type ClientOption func(*DeltaLakeClient)
+
+// After every `interval` committed transactions, the committing client writes a checkpoint of the whole log so new
+// transactions don't need to replay it from the start. Zero disables writing checkpoints (existing ones are still
+// used).
+func WithCheckpointInterval(interval int) ClientOption {
+	return func(d *DeltaLakeClient) {
+		d.checkpointInterval = interval
+	}
+}
+
+// When a commit loses the race for its log entry to another transaction that didn't touch anything this one read or
+// wrote, it is moved after that transaction and retried, up to `retries` times. Zero means concurrent commits always
+// fail.
+func WithCommitRetries(retries int) ClientOption {
+	return func(d *DeltaLakeClient) {
+		d.commitRetries = retries
+	}
+}
+
+// Rows written are flushed to a new dataobject once `rows` of them have accumulated (DATAOBJECT_SIZE by default). Zero
+// means there's no limit on the number of rows, so they're only flushed when they reach WithMaxDataobjectBytes, or on
+// commit.
+func WithMaxDataobjectRows(rows int) ClientOption {
+	return func(d *DeltaLakeClient) {
+		d.maxDataobjectRows = rows
+	}
+}
+
+// Rows written are flushed to a new dataobject once they add up to roughly `bytes` (going by the size of their
+// values, before encoding and compression). Zero, the default, means there's no limit on the size.
+func WithMaxDataobjectBytes(bytes int) ClientOption {
+	return func(d *DeltaLakeClient) {
+		d.maxDataobjectBytes = bytes
+	}
 }
 
-func NewClient(os objectstorage.ObjectStorage) DeltaLakeClient {
-	return DeltaLakeClient{os, nil}
+func NewClient(os objectstorage.ObjectStorage, opts ...ClientOption) DeltaLakeClient {
+	d := DeltaLakeClient{
+		os:                 os,
+		checkpointInterval: DEFAULT_CHECKPOINT_INTERVAL,
+		commitRetries:      DEFAULT_COMMIT_RETRIES,
+		maxDataobjectRows:  DATAOBJECT_SIZE,
+		metrics:            &clientMetrics{},
+		snapshots:          &snapshotCache{},
+	}
+	for _, opt := range opts {
+		opt(&d)
+	}
+	return d
 }
 
 var (
-	errExistingTx   = fmt.Errorf("Existing Transaction")
-	errNoTx         = fmt.Errorf("No Transaction")
-	errTableExists  = fmt.Errorf("Table Exists")
-	errNoTable      = fmt.Errorf("No Such Table")
-	errTypeMismatch = fmt.Errorf("Type mismatch")
+	errExistingTx            = fmt.Errorf("Existing Transaction")
+	errNoTx                  = fmt.Errorf("No Transaction")
+	errTableExists           = fmt.Errorf("Table Exists")
+	errNoTable               = fmt.Errorf("No Such Table")
+	errNoColumn              = fmt.Errorf("No Such Column")
+	errTypeMismatch          = fmt.Errorf("Type mismatch")
+	errReadOnlyTx            = fmt.Errorf("Read-only Transaction")
+	errNoVersion             = fmt.Errorf("No Such Version")
+	errConflict              = fmt.Errorf("Conflicting Transaction")
+	errInvalidSchema         = fmt.Errorf("Invalid Schema")
+	errNoPrimaryKey          = fmt.Errorf("Table Has No Primary Key")
+	errPrimaryKeyUpdate      = fmt.Errorf("Can't Update Primary Key")
+	errCorruptDeletionVector = fmt.Errorf("Corrupt Deletion Vector")
+	errCorruptDataobject     = fmt.Errorf("Corrupt Dataobject")
 )
@@ -0,0 +1,194 @@
+package deltalakeclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/rptynan/delta-lake/utils"
+)
+
+// Removes `_table_` files that aren't part of the latest version of their table, and haven't been for at least
+// `retention`. That covers dataobjects replaced by copy-on-write deletes as well as ones written by transactions that
+// never committed. Registered Parquet files are never removed, even if they're named like a dataobject.
+//
+// Readers of older snapshots still need the files that have since been deleted, so retention should be longer than
+// the longest running transaction (reader or writer). A file counts as unreferenced from the time the log entry that
+// deleted it was written, or if it was never committed, from when the file itself was written.
+//
+// Must be called outside of a transaction, it uses its own to get the latest version of the log.
+func (d *DeltaLakeClient) Vacuum(ctx context.Context, retention time.Duration) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+
+	err := d.NewTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { d.tx = nil }()
+
+	// Files in the latest version of each table, and when the others were deleted. Registered files are never ours to
+	// delete, even once they're no longer part of the table, so they count as referenced forever.
+	referenced := map[string]struct{}{}
+	deletedByTxId := map[string]int{}
+	for table, actions := range d.tx.previousActions {
+		for _, dataobjectAction := range d.listExtantDataobjects(table) {
+			referenced[dataobjectAction.filename()] = struct{}{}
+		}
+		for _, action := range actions {
+			if action.AddDataobject != nil && action.AddDataobject.Path != "" {
+				referenced[action.AddDataobject.Path] = struct{}{}
+			}
+			if action.DeleteDataobject != nil {
+				deletedByTxId[action.DeleteDataobject.filename()] = action.DeleteDataobject.TxId
+			}
+		}
+	}
+
+	filenames, err := d.os.ListPrefixOrdered(ctx, "_table_")
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	for _, filename := range filenames {
+		if _, ok := referenced[filename]; ok {
+			continue
+		}
+
+		unreferencedSince, err := d.unreferencedSince(ctx, filename, deletedByTxId)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Someone else vacuumed it already.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if unreferencedSince.After(cutoff) {
+			continue
+		}
+
+		utils.Debug("vacuum: deleting", filename)
+		err = d.os.Delete(ctx, filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *DeltaLakeClient) unreferencedSince(
+	ctx context.Context, filename string, deletedByTxId map[string]int,
+) (time.Time, error) {
+	statName := filename
+	if txId, ok := deletedByTxId[filename]; ok {
+		statName = fmt.Sprintf("_log_%020d", txId)
+	}
+	info, err := d.os.Stat(ctx, statName)
+	return info.ModTime, err
+}
+
+// Compacts runs of small dataobjects in the table into fewer ones of up to targetRows rows (by default, if it isn't
+// positive, the client's WithMaxDataobjectRows or DATAOBJECT_SIZE if that's unlimited), dropping rows deleted by
+// deletion vectors on the way.
+//
+// Like Vacuum, this runs in its own transaction. It doesn't count as reading the table, so it won't conflict with
+// concurrent writers appending to it, but does with anything deleting from the dataobjects it rewrites. The replaced
+// files are left for Vacuum.
+func (d *DeltaLakeClient) Optimize(ctx context.Context, table string, targetRows int) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+	if targetRows <= 0 {
+		targetRows = d.maxDataobjectRows
+	}
+	if targetRows <= 0 {
+		targetRows = DATAOBJECT_SIZE
+	}
+
+	err := d.NewTx(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := d.tx.tables[table]; !ok {
+		d.tx = nil
+		return errNoTable
+	}
+
+	// Only consecutive dataobjects can be merged, otherwise rows would move past rows written in between them.
+	var group []extantDataobject
+	var groupRows [][]any
+	for _, object := range d.listExtantDataobjects(table) {
+		// Full dataobjects are left alone, and their stats tell us which those are without reading them.
+		if object.deletionVector == nil && object.Stats != nil && object.Stats.Rows >= targetRows {
+			err = d.compactDataobjects(ctx, table, group, groupRows)
+			if err != nil {
+				return d.abortTx(ctx, err)
+			}
+			group, groupRows = nil, nil
+			continue
+		}
+
+		dataobject, err := d.readDataobject(ctx, table, object)
+		if err != nil {
+			return d.abortTx(ctx, err)
+		}
+		var rows [][]any
+		for _, row := range dataobject.Data {
+			if row != nil {
+				rows = append(rows, row)
+			}
+		}
+
+		if len(groupRows)+len(rows) > targetRows {
+			err = d.compactDataobjects(ctx, table, group, groupRows)
+			if err != nil {
+				return d.abortTx(ctx, err)
+			}
+			group, groupRows = nil, nil
+		}
+
+		// Same for ones from before we kept stats.
+		if len(rows) >= targetRows && object.deletionVector == nil {
+			continue
+		}
+		group = append(group, object)
+		groupRows = append(groupRows, rows...)
+	}
+	err = d.compactDataobjects(ctx, table, group, groupRows)
+	if err != nil {
+		return d.abortTx(ctx, err)
+	}
+
+	return d.CommitTx(ctx)
+}
+
+// Replaces the (consecutive) dataobjects in group with one containing rows, unless there's nothing to gain.
+func (d *DeltaLakeClient) compactDataobjects(
+	ctx context.Context, table string, group []extantDataobject, rows [][]any,
+) error {
+	if len(group) == 0 || (len(group) == 1 && group[0].deletionVector == nil) {
+		return nil
+	}
+
+	if len(rows) > 0 {
+		// The new dataobject takes the place of the last one in the group, which puts it after everything before the
+		// group and before everything after it.
+		last := group[len(group)-1]
+		addDataobjectAction, err := d.writeDataObject(ctx, table, rows, last.TxId, last.Seq)
+		if err != nil {
+			return err
+		}
+		d.tx.Actions[table] = append(d.tx.Actions[table], addDataobjectAction)
+	}
+
+	for _, object := range group {
+		d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+			DeleteDataobject: &dataobjectActionT{Name: object.Name, Table: table, TxId: d.tx.Id, Path: object.Path},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package deltalakeclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rptynan/delta-lake/parquet"
+)
+
+type parquetCodec struct {
+	// For registered files, the ids of the table's columns that the file's columns are, see dataobjectActionT.Columns.
+	// Otherwise these come from the file's field ids.
+	columnIds []int
+}
+
+// Decimals can't be stored as Parquet decimals, which have a fixed scale, so they're strings (e.g. "1999/100").
+func parquetField(column Column) parquet.Field {
+	field := parquet.Field{Name: column.Name, Optional: column.Nullable, Id: column.Id}
+	switch column.Type {
+	case TypeAny:
+		field.Type, field.Logical = parquet.ByteArray, parquet.JSON
+	case TypeInt64:
+		field.Type = parquet.Int64
+	case TypeFloat64:
+		field.Type = parquet.Double
+	case TypeString, TypeDecimal:
+		field.Type, field.Logical = parquet.ByteArray, parquet.String
+	case TypeBool:
+		field.Type = parquet.Boolean
+	case TypeBytes:
+		field.Type = parquet.ByteArray
+	case TypeTimestamp:
+		field.Type, field.Logical = parquet.Int64, parquet.TimestampNanos
+	case TypeDate:
+		field.Type, field.Logical = parquet.Int32, parquet.Date
+	}
+	return field
+}
+
+// Timestamps are stored as nanoseconds since the epoch in an int64, so only these can be stored.
+var (
+	minParquetTimestamp = time.Unix(0, math.MinInt64).UTC()
+	maxParquetTimestamp = time.Unix(0, math.MaxInt64).UTC()
+)
+
+func toParquetValue(column Column, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch column.Type {
+	case TypeAny:
+		return json.Marshal(value)
+	case TypeString:
+		return []byte(value.(string)), nil
+	case TypeDecimal:
+		return []byte(value.(*big.Rat).RatString()), nil
+	case TypeTimestamp:
+		t := value.(time.Time)
+		if t.Before(minParquetTimestamp) || t.After(maxParquetTimestamp) {
+			return nil, fmt.Errorf(
+				"%w: column %s has value %v, which is outside the years Parquet nanosecond timestamps can hold (%v to %v)",
+				errTypeMismatch, column.Name, t, minParquetTimestamp, maxParquetTimestamp,
+			)
+		}
+		return t.UnixNano(), nil
+	case TypeDate:
+		return int32(value.(time.Time).Unix() / (24 * 60 * 60)), nil
+	default:
+		return value, nil
+	}
+}
+
+func (parquetCodec) encode(do *dataobjectT, columns []Column) ([]byte, error) {
+	fields := make([]parquet.Field, len(columns))
+	for i, column := range columns {
+		fields[i] = parquetField(column)
+	}
+
+	rows := make([][]any, len(do.Data))
+	for i, row := range do.Data {
+		rows[i] = make([]any, len(columns))
+		for j, column := range columns {
+			var err error
+			rows[i][j], err = toParquetValue(column, row[j])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return parquet.Write(fields, rows)
+}
+
+func (c parquetCodec) decode(data []byte, columns []Column) (*dataobjectT, error) {
+	file, err := parquet.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := file.Rows()
+	if err != nil {
+		return nil, err
+	}
+
+	do := &dataobjectT{Data: rows, Columns: c.columnIds}
+	if do.Columns == nil {
+		for _, field := range file.Fields {
+			do.Columns = append(do.Columns, field.Id)
+		}
+	}
+	if len(do.Columns) != len(file.Fields) {
+		return nil, errCorruptDataobject
+	}
+
+	// Where each field's column is in columns. Columns that have since been dropped are -1, and left alone, they won't be
+	// read.
+	positions := make([]int, len(file.Fields))
+	for j := range file.Fields {
+		positions[j] = slices.IndexFunc(columns, func(c Column) bool { return c.Id == do.Columns[j] })
+	}
+	for _, row := range rows {
+		for j, field := range file.Fields {
+			k := positions[j]
+			if k == -1 {
+				continue
+			}
+			row[j], err = fromParquetValue(field, columns[k], row[j])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return do, nil
+}
+
+// Converts a value read from a Parquet file, which may not have been written by us, to the Go type of the column.
+func fromParquetValue(field parquet.Field, column Column, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	// First apply the logical type.
+	switch v := value.(type) {
+	case int32:
+		switch field.Logical {
+		case parquet.Date:
+			value = time.Unix(int64(v)*24*60*60, 0).UTC()
+		case parquet.Decimal:
+			value = new(big.Rat).SetFrac(big.NewInt(int64(v)), pow10(field.Scale))
+		}
+	case int64:
+		switch field.Logical {
+		case parquet.TimestampMillis:
+			value = time.UnixMilli(v).UTC()
+		case parquet.TimestampMicros:
+			value = time.UnixMicro(v).UTC()
+		case parquet.TimestampNanos:
+			value = time.Unix(0, v).UTC()
+		case parquet.Decimal:
+			value = new(big.Rat).SetFrac(big.NewInt(v), pow10(field.Scale))
+		}
+	case [12]byte:
+		// Legacy timestamps: nanoseconds into the day, then the Julian day.
+		nanos := int64(uint64(v[0]) | uint64(v[1])<<8 | uint64(v[2])<<16 | uint64(v[3])<<24 |
+			uint64(v[4])<<32 | uint64(v[5])<<40 | uint64(v[6])<<48 | uint64(v[7])<<56)
+		day := int64(uint32(v[8]) | uint32(v[9])<<8 | uint32(v[10])<<16 | uint32(v[11])<<24)
+		const julianUnixEpoch = 2440588
+		value = time.Unix((day-julianUnixEpoch)*24*60*60, nanos).UTC()
+	case float32:
+		value = float64(v)
+	case []byte:
+		switch field.Logical {
+		case parquet.String:
+			value = string(v)
+		case parquet.JSON:
+			decoder := json.NewDecoder(bytes.NewReader(v))
+			decoder.UseNumber()
+			var decoded any
+			err := decoder.Decode(&decoded)
+			if err != nil {
+				return nil, err
+			}
+			value = fromJSONNumbers(decoded)
+		case parquet.Decimal:
+			// Big-endian two's complement.
+			unscaled := new(big.Int).SetBytes(v)
+			if len(v) > 0 && v[0]&0x80 != 0 {
+				unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(v))))
+			}
+			value = new(big.Rat).SetFrac(unscaled, pow10(field.Scale))
+		default:
+			value = bytes.Clone(v)
+		}
+	}
+
+	// Then convert it to the column's type.
+	if s, ok := value.(string); ok && column.Type == TypeDecimal {
+		r, ok := new(big.Rat).SetString(s)
+		if ok {
+			return r, nil
+		}
+	}
+	converted, ok := convertValue(column.Type, value)
+	if !ok {
+		return nil, fmt.Errorf(
+			"%w: column %s has type %s, got %v (%T) from Parquet", errTypeMismatch, column.Name, column.Type, value, value,
+		)
+	}
+	return converted, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Adds an existing Parquet file (already in object storage at `path`) to the table as a dataobject, without rewriting
+// it. Its columns are matched to the table's by name, and any of the table's columns it doesn't have get their
+// default. The file is only read, never changed or deleted, so e.g. copy-on-writes of it are written as new
+// dataobjects in the table's own codec.
+func (d *DeltaLakeClient) RegisterParquetFile(ctx context.Context, table string, path string) error {
+	if d.tx == nil {
+		return errNoTx
+	}
+	if d.tx.readOnly {
+		return errReadOnlyTx
+	}
+
+	metadata, ok := d.tx.tables[table]
+	if !ok {
+		return errNoTable
+	}
+	data, err := d.os.Read(ctx, path)
+	if err != nil {
+		return err
+	}
+	file, err := parquet.Open(data)
+	if err != nil {
+		return err
+	}
+
+	var columns []Column
+	var columnIds []int
+	for _, field := range file.Fields {
+		i := columnIndex(metadata.Columns, field.Name)
+		if i == -1 || slices.Contains(columnIds, metadata.Columns[i].Id) {
+			return fmt.Errorf("%w: file column %s doesn't match a column of table %s", errTypeMismatch, field.Name, table)
+		}
+		columns = append(columns, metadata.Columns[i])
+		columnIds = append(columnIds, metadata.Columns[i].Id)
+	}
+	for _, column := range metadata.Columns {
+		if !slices.Contains(columnIds, column.Id) && !column.Nullable && column.Default == nil {
+			return fmt.Errorf("%w: file has no values for column %s", errTypeMismatch, column.Name)
+		}
+	}
+
+	// Check we can actually read it (and that the values fit the columns) now, rather than on the next scan.
+	codec := parquetCodec{columnIds: columnIds}
+	do, err := codec.decode(data, metadata.Columns)
+	if err != nil {
+		return err
+	}
+	rows := make([][]any, len(do.Data))
+	positions := columnPositions(columnIds, metadata.Columns)
+	for i, row := range do.Data {
+		_, err = validateRow(columns, row)
+		if err != nil {
+			return err
+		}
+		rows[i] = projectRow(row, positions, metadata.Columns)
+	}
+
+	// Rows written before this must come before the file, so flush them first to give them the lower Seq.
+	err = d.flushRows(ctx, table)
+	if err != nil {
+		return err
+	}
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+		AddDataobject: &dataobjectActionT{
+			Name: uuid.New().String(), Table: table, TxId: d.tx.Id, Seq: d.nextSeq(table), Path: path, Columns: columnIds,
+			Stats: computeStats(metadata.Columns, rows),
+		},
+	})
+	return nil
+}
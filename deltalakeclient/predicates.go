@@ -0,0 +1,482 @@
+package deltalakeclient
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/rptynan/delta-lake/utils"
+)
+
+// Selects rows of a table, e.g. for UpdateRows.
+type Predicate interface {
+	// Returns the predicate for rows with these columns.
+	bind(columns []Column) (boundPredicate, error)
+}
+
+type rowMatcher func(row []any) (bool, error)
+
+type boundPredicate struct {
+	matches rowMatcher
+	// Whether a dataobject with these stats might have rows that match. Must only return false if it definitely
+	// doesn't.
+	mightMatch func(stats *dataobjectStats) bool
+	// Ids of the columns the predicate looks at.
+	columnIds []int
+}
+
+// Whether scans of the table can skip dataobjects with mightMatch. With a primary key, that's only if the predicate
+// just looks at primary key columns, which are the same in every version of a row. Otherwise we might skip the latest
+// version of a row and return an older one instead.
+func (p boundPredicate) canSkipDataobjects(metadata *changeMetadataAction) bool {
+	if len(metadata.PrimaryKey) == 0 {
+		return true
+	}
+	for _, id := range p.columnIds {
+		if !slices.Contains(metadata.PrimaryKey, id) {
+			return false
+		}
+	}
+	return true
+}
+
+type columnEquals struct {
+	column string
+	value  any
+}
+
+// Matches rows where the column's value equals value. Nulls don't equal anything, even nil.
+func ColumnEquals(column string, value any) Predicate {
+	return columnEquals{column: column, value: value}
+}
+
+func (p columnEquals) bind(columns []Column) (boundPredicate, error) {
+	columnIndex := columnIndex(columns, p.column)
+	if columnIndex == -1 {
+		return boundPredicate{}, fmt.Errorf("%w: %s", errNoColumn, p.column)
+	}
+	column := columns[columnIndex]
+
+	var value any
+	var key string
+	ok := p.value != nil
+	if ok {
+		value, ok = convertValue(column.Type, p.value)
+		if !ok {
+			return boundPredicate{}, fmt.Errorf(
+				"%w: column %s has type %s, got %v (%T)", errTypeMismatch, column.Name, column.Type, p.value, p.value,
+			)
+		}
+		key, ok = valueKey(value)
+	}
+	if !ok {
+		return matchNothing(column), nil
+	}
+
+	return boundPredicate{
+		matches: func(row []any) (bool, error) {
+			if row[columnIndex] == nil {
+				return false, nil
+			}
+			rowKey, ok := valueKey(row[columnIndex])
+			return ok && rowKey == key, nil
+		},
+		mightMatch: func(stats *dataobjectStats) bool {
+			columnStats := stats.column(column.Id)
+			if columnStats == nil {
+				return true
+			}
+			if columnStats.Nulls == stats.Rows {
+				return false
+			}
+			if columnStats.BloomFilter != nil && !bloomFilterContains(columnStats.BloomFilter, key) {
+				return false
+			}
+			min, max, ok := columnStats.bounds(column)
+			if !ok {
+				return true
+			}
+			c1, ok1 := compareValues(value, min)
+			c2, ok2 := compareValues(value, max)
+			return !ok1 || !ok2 || (c1 >= 0 && c2 <= 0)
+		},
+		columnIds: []int{column.Id},
+	}, nil
+}
+
+type QueryRange struct {
+	// Inclusive. Both ends need to be the same kind of value: ints, strings, floats, time.Times or *big.Rats. Ints
+	// match integers and floats (truncated, see utils.AsInt), and the rest match values they can be compared to.
+	Start any
+	End   any
+}
+
+// Checks the range makes sense before we use it on any rows.
+func (r QueryRange) validate(column Column) error {
+	var ok bool
+	switch r.Start.(type) {
+	case int:
+		_, ok = r.End.(int)
+	default:
+		_, ok = compareValues(r.Start, r.End)
+	}
+	if !ok {
+		return fmt.Errorf(
+			"%w: column %s can't be in the range %v (%T) to %v (%T)", errTypeMismatch, column.Name, r.Start, r.Start, r.End,
+			r.End,
+		)
+	}
+	return nil
+}
+
+func inRange(column Column, columnIndex int, queryRange QueryRange, row []any) (bool, error) {
+	value := row[columnIndex]
+	// Nulls aren't in any range, e.g. rows from before the column was added without a default. Neither is NaN.
+	if f, ok := value.(float64); value == nil || (ok && math.IsNaN(f)) {
+		return false, nil
+	}
+
+	var c1, c2 int
+	var ok1, ok2 bool
+	if start, ok := queryRange.Start.(int); ok {
+		val, err := utils.AsInt(value)
+		c1, c2, ok1, ok2 = cmp.Compare(start, val), cmp.Compare(val, queryRange.End.(int)), err == nil, err == nil
+	} else {
+		c1, ok1 = compareValues(queryRange.Start, value)
+		c2, ok2 = compareValues(value, queryRange.End)
+	}
+	if !ok1 || !ok2 {
+		return false, fmt.Errorf(
+			"%w: column %s has value %v (%T), which can't be compared to the range %v to %v", errTypeMismatch,
+			column.Name, value, value, queryRange.Start, queryRange.End,
+		)
+	}
+	return c1 <= 0 && c2 <= 0, nil
+}
+
+// Whether any value from min to max could be in the range. If they can't be compared to the range, it assumes there
+// could be, and reading the rows will find out they don't match.
+func rangeOverlaps(queryRange QueryRange, min, max any) bool {
+	var c1, c2 int
+	var ok1, ok2 bool
+	if start, ok := queryRange.Start.(int); ok {
+		minVal, err1 := utils.AsInt(min)
+		maxVal, err2 := utils.AsInt(max)
+		c1, c2, ok1, ok2 = cmp.Compare(minVal, queryRange.End.(int)), cmp.Compare(start, maxVal), err1 == nil, err2 == nil
+	} else {
+		c1, ok1 = compareValues(min, queryRange.End)
+		c2, ok2 = compareValues(queryRange.Start, max)
+	}
+	return !ok1 || !ok2 || (c1 <= 0 && c2 <= 0)
+}
+
+type columnInRange struct {
+	column     string
+	queryRange QueryRange
+}
+
+// Matches rows where the column's value is within the range, see QueryRange.
+func ColumnInRange(column string, queryRange QueryRange) Predicate {
+	return columnInRange{column: column, queryRange: queryRange}
+}
+
+func (p columnInRange) bind(columns []Column) (boundPredicate, error) {
+	columnIndex := columnIndex(columns, p.column)
+	if columnIndex == -1 {
+		return boundPredicate{}, fmt.Errorf("%w: %s", errNoColumn, p.column)
+	}
+	column := columns[columnIndex]
+	err := p.queryRange.validate(column)
+	if err != nil {
+		return boundPredicate{}, err
+	}
+
+	return boundPredicate{
+		matches: func(row []any) (bool, error) {
+			return inRange(column, columnIndex, p.queryRange, row)
+		},
+		mightMatch: func(stats *dataobjectStats) bool {
+			columnStats := stats.column(column.Id)
+			if columnStats == nil {
+				return true
+			}
+			// Nulls aren't in any range.
+			if columnStats.Nulls == stats.Rows {
+				return false
+			}
+			min, max, ok := columnStats.bounds(column)
+			return !ok || rangeOverlaps(p.queryRange, min, max)
+		},
+		columnIds: []int{column.Id},
+	}, nil
+}
+
+type CompareOp string
+
+const (
+	OpEq CompareOp = "="
+	OpNe CompareOp = "!="
+	OpLt CompareOp = "<"
+	OpLe CompareOp = "<="
+	OpGt CompareOp = ">"
+	OpGe CompareOp = ">="
+)
+
+type columnCompare struct {
+	column string
+	op     CompareOp
+	value  any
+}
+
+// Matches rows where comparing the column's value to value with op is true. Like in SQL, nulls don't compare to
+// anything, so never match. Values of untyped columns that can't be compared to value (e.g. a string and a number)
+// don't match either.
+func ColumnCompare(column string, op CompareOp, value any) Predicate {
+	return columnCompare{column: column, op: op, value: value}
+}
+
+func (p columnCompare) bind(columns []Column) (boundPredicate, error) {
+	if p.op == OpEq {
+		return columnEquals{column: p.column, value: p.value}.bind(columns)
+	}
+
+	columnIndex := columnIndex(columns, p.column)
+	if columnIndex == -1 {
+		return boundPredicate{}, fmt.Errorf("%w: %s", errNoColumn, p.column)
+	}
+	column := columns[columnIndex]
+	if p.value == nil {
+		return matchNothing(column), nil
+	}
+	value, err := validateValue(column, p.value)
+	if err != nil {
+		return boundPredicate{}, err
+	}
+
+	var holds func(c int) bool
+	switch p.op {
+	case OpNe:
+		holds = func(c int) bool { return c != 0 }
+	case OpLt:
+		holds = func(c int) bool { return c < 0 }
+	case OpLe:
+		holds = func(c int) bool { return c <= 0 }
+	case OpGt:
+		holds = func(c int) bool { return c > 0 }
+	case OpGe:
+		holds = func(c int) bool { return c >= 0 }
+	default:
+		return boundPredicate{}, fmt.Errorf("%w: unknown comparison %q", errTypeMismatch, p.op)
+	}
+	if p.op != OpNe && (column.Type == TypeBool || column.Type == TypeBytes) {
+		return boundPredicate{}, fmt.Errorf(
+			"%w: column %s has type %s, which can't be ordered", errTypeMismatch, column.Name, column.Type,
+		)
+	}
+	key, keyed := valueKey(value)
+
+	return boundPredicate{
+		matches: func(row []any) (bool, error) {
+			if row[columnIndex] == nil {
+				return false, nil
+			}
+			if p.op == OpNe {
+				rowKey, ok := valueKey(row[columnIndex])
+				return ok && keyed && rowKey != key, nil
+			}
+			c, ok := compareValues(row[columnIndex], value)
+			return ok && holds(c), nil
+		},
+		mightMatch: func(stats *dataobjectStats) bool {
+			columnStats := stats.column(column.Id)
+			if columnStats == nil {
+				return true
+			}
+			if columnStats.Nulls == stats.Rows {
+				return false
+			}
+			min, max, ok := columnStats.bounds(column)
+			if !ok {
+				return true
+			}
+			// Whether the comparison holds for any value from min to max.
+			c1, ok1 := compareValues(min, value)
+			c2, ok2 := compareValues(max, value)
+			if !ok1 || !ok2 {
+				return true
+			}
+			switch p.op {
+			case OpNe:
+				return c1 != 0 || c2 != 0
+			case OpLt, OpLe:
+				return holds(c1)
+			default:
+				return holds(c2)
+			}
+		},
+		columnIds: []int{column.Id},
+	}, nil
+}
+
+type columnIsNull struct {
+	column string
+}
+
+func ColumnIsNull(column string) Predicate {
+	return columnIsNull{column: column}
+}
+
+func (p columnIsNull) bind(columns []Column) (boundPredicate, error) {
+	columnIndex := columnIndex(columns, p.column)
+	if columnIndex == -1 {
+		return boundPredicate{}, fmt.Errorf("%w: %s", errNoColumn, p.column)
+	}
+	column := columns[columnIndex]
+
+	return boundPredicate{
+		matches: func(row []any) (bool, error) {
+			return row[columnIndex] == nil, nil
+		},
+		mightMatch: func(stats *dataobjectStats) bool {
+			columnStats := stats.column(column.Id)
+			return columnStats == nil || columnStats.Nulls > 0
+		},
+		columnIds: []int{column.Id},
+	}, nil
+}
+
+// Matches rows where the column equals any of the values.
+func ColumnIn(column string, values ...any) Predicate {
+	predicates := make([]Predicate, len(values))
+	for i, value := range values {
+		predicates[i] = ColumnEquals(column, value)
+	}
+	return Or(predicates...)
+}
+
+type and []Predicate
+
+// Matches rows that match all of the predicates (so every row, if there are none).
+func And(predicates ...Predicate) Predicate {
+	return and(predicates)
+}
+
+func (p and) bind(columns []Column) (boundPredicate, error) {
+	children, err := bindAll(p, columns)
+	if err != nil {
+		return boundPredicate{}, err
+	}
+
+	return boundPredicate{
+		matches: func(row []any) (bool, error) {
+			for _, child := range children {
+				r, err := child.matches(row)
+				if err != nil || !r {
+					return false, err
+				}
+			}
+			return true, nil
+		},
+		mightMatch: func(stats *dataobjectStats) bool {
+			for _, child := range children {
+				if !child.mightMatch(stats) {
+					return false
+				}
+			}
+			return true
+		},
+		columnIds: childColumnIds(children),
+	}, nil
+}
+
+type or []Predicate
+
+// Matches rows that match any of the predicates (so no rows, if there are none).
+func Or(predicates ...Predicate) Predicate {
+	return or(predicates)
+}
+
+func (p or) bind(columns []Column) (boundPredicate, error) {
+	children, err := bindAll(p, columns)
+	if err != nil {
+		return boundPredicate{}, err
+	}
+
+	return boundPredicate{
+		matches: func(row []any) (bool, error) {
+			for _, child := range children {
+				r, err := child.matches(row)
+				if err != nil || r {
+					return r, err
+				}
+			}
+			return false, nil
+		},
+		mightMatch: func(stats *dataobjectStats) bool {
+			for _, child := range children {
+				if child.mightMatch(stats) {
+					return true
+				}
+			}
+			return false
+		},
+		columnIds: childColumnIds(children),
+	}, nil
+}
+
+type not struct {
+	predicate Predicate
+}
+
+// Matches rows that don't match the predicate. Unlike SQL's NOT, that includes rows the predicate didn't match because
+// of nulls, e.g. Not(ColumnCompare("a", OpEq, 1)) matches rows where a is null.
+func Not(predicate Predicate) Predicate {
+	return not{predicate: predicate}
+}
+
+func (p not) bind(columns []Column) (boundPredicate, error) {
+	child, err := p.predicate.bind(columns)
+	if err != nil {
+		return boundPredicate{}, err
+	}
+
+	return boundPredicate{
+		matches: func(row []any) (bool, error) {
+			r, err := child.matches(row)
+			return !r, err
+		},
+		// The child might match only some of the rows in a dataobject, so we can't tell anything from its stats.
+		mightMatch: func(stats *dataobjectStats) bool { return true },
+		columnIds:  child.columnIds,
+	}, nil
+}
+
+func bindAll(predicates []Predicate, columns []Column) ([]boundPredicate, error) {
+	bound := make([]boundPredicate, len(predicates))
+	for i, predicate := range predicates {
+		var err error
+		bound[i], err = predicate.bind(columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bound, nil
+}
+
+func childColumnIds(children []boundPredicate) []int {
+	var ids []int
+	for _, child := range children {
+		ids = append(ids, child.columnIds...)
+	}
+	return ids
+}
+
+// For predicates that can't match any row, but still look at the column.
+func matchNothing(column Column) boundPredicate {
+	return boundPredicate{
+		matches:    func(row []any) (bool, error) { return false, nil },
+		mightMatch: func(stats *dataobjectStats) bool { return false },
+		columnIds:  []int{column.Id},
+	}
+}
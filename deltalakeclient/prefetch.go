@@ -0,0 +1,158 @@
+package deltalakeclient
+
+import (
+	"context"
+	"sync"
+)
+
+// Reads a scan's dataobjects ahead of it, with up to `window` of them being read or waiting to be returned at once (so
+// up to that many reads in flight).
+type prefetcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Latest first, i.e. the order an ordered scan returns them in.
+	jobs      []*prefetchJob
+	unordered bool
+	// Jobs are sent here as they finish, for unordered scans.
+	finished chan *prefetchJob
+
+	// Protects everything below. Workers wait on cond for room in the window (or budget) to start the next job.
+	mu   sync.Mutex
+	cond *sync.Cond
+	// Jobs started by workers, and returned by next. The jobs in between are being read or waiting to be returned.
+	started, returned int
+	window            int
+	// Sizes of the dataobjects read but not returned yet, and the most we want there to be. Zero means no limit.
+	bufferedBytes, maxBufferedBytes int
+	closed                          bool
+}
+
+type prefetchJob struct {
+	object extantDataobject
+	// Closed once do and err are set.
+	done chan struct{}
+	do   *dataobjectT
+	err  error
+}
+
+func newPrefetcher(
+	ctx context.Context,
+	d *DeltaLakeClient,
+	metadata *changeMetadataAction,
+	objects []extantDataobject,
+	window int,
+	maxBufferedBytes int,
+	unordered bool,
+) *prefetcher {
+	p := &prefetcher{
+		unordered:        unordered,
+		finished:         make(chan *prefetchJob, len(objects)),
+		window:           window,
+		maxBufferedBytes: maxBufferedBytes,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	// Wake up any workers waiting for room once we're closed or the scan's context is done, so they can exit.
+	context.AfterFunc(p.ctx, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.closed = true
+		p.cond.Broadcast()
+	})
+
+	for i := len(objects) - 1; i >= 0; i-- {
+		p.jobs = append(p.jobs, &prefetchJob{object: objects[i], done: make(chan struct{})})
+	}
+	for range min(window, len(objects)) {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.work(d, metadata)
+		}()
+	}
+	return p
+}
+
+func (p *prefetcher) work(d *DeltaLakeClient, metadata *changeMetadataAction) {
+	for {
+		job := p.start()
+		if job == nil {
+			return
+		}
+
+		job.do, job.err = d.readDataobjectAs(p.ctx, metadata, job.object)
+		if job.do != nil {
+			p.mu.Lock()
+			p.bufferedBytes += job.do.size
+			p.mu.Unlock()
+		}
+		close(job.done)
+		if p.unordered {
+			p.finished <- job
+		}
+	}
+}
+
+// Waits until there's room to start the next job and returns it, or nil if there are no more or we're closed.
+func (p *prefetcher) start() *prefetchJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.closed || p.started == len(p.jobs) {
+			return nil
+		}
+		ahead := p.started - p.returned
+		overBudget := p.maxBufferedBytes > 0 && p.bufferedBytes >= p.maxBufferedBytes
+		// Always allow one read ahead, otherwise a dataobject bigger than the budget would stop the scan.
+		if ahead == 0 || (ahead < p.window && !overBudget) {
+			job := p.jobs[p.started]
+			p.started++
+			return job
+		}
+		p.cond.Wait()
+	}
+}
+
+// Returns the next dataobject, latest first unless unordered, or nil once they've all been returned.
+func (p *prefetcher) next() (*dataobjectT, error) {
+	if p.returned == len(p.jobs) {
+		p.close()
+		return nil, nil
+	}
+
+	var job *prefetchJob
+	if p.unordered {
+		select {
+		case job = <-p.finished:
+		case <-p.ctx.Done():
+			return nil, p.ctx.Err()
+		}
+	} else {
+		job = p.jobs[p.returned]
+		select {
+		case <-job.done:
+		case <-p.ctx.Done():
+			return nil, p.ctx.Err()
+		}
+	}
+
+	do, err := job.do, job.err
+	// The scan holds on to it from here, we don't need to.
+	job.do = nil
+	p.mu.Lock()
+	p.returned++
+	if do != nil {
+		p.bufferedBytes -= do.size
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	return do, err
+}
+
+// Stops the workers, cancelling any reads in flight, and waits for them to exit.
+func (p *prefetcher) close() {
+	p.cancel()
+	p.wg.Wait()
+}
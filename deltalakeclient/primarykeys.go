@@ -0,0 +1,98 @@
+package deltalakeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Writes a row to a table with a primary key, replacing any existing row with the same key. Nothing is rewritten,
+// the new row is just appended, and because scans return rows latest first they can skip older versions of each key.
+// That makes replaying the same rows idempotent.
+func (d *DeltaLakeClient) Upsert(ctx context.Context, table string, row []any) error {
+	if d.tx == nil {
+		return errNoTx
+	}
+
+	metadata, ok := d.tx.tables[table]
+	if !ok {
+		return errNoTable
+	}
+	if len(metadata.PrimaryKey) == 0 {
+		return errNoPrimaryKey
+	}
+
+	return d.WriteRow(ctx, table, row)
+}
+
+// Indexes of the primary key columns in rows of the table's current schema, nil if it has no primary key.
+func primaryKeyPositions(metadata *changeMetadataAction) []int {
+	var positions []int
+	for _, id := range metadata.PrimaryKey {
+		positions = append(positions, slices.IndexFunc(metadata.Columns, func(c Column) bool { return c.Id == id }))
+	}
+	return positions
+}
+
+// Returns a string that is the same for any two rows with equal primary keys. Goes via JSON so that e.g. an int in an
+// unflushed untyped row matches the float64 it turns into once flushed.
+func primaryKeyOf(row []any, positions []int) string {
+	var key strings.Builder
+	key.WriteByte('[')
+	for i, position := range positions {
+		if i > 0 {
+			key.WriteByte(',')
+		}
+		key.WriteString(primaryKeyValue(row[position]))
+	}
+	key.WriteByte(']')
+	return key.String()
+}
+
+// JSON has no NaN or infinities, but they're valid float keys (and the columnar codec stores them), so those are written
+// as e.g. NaN or +Inf, which can't clash with any JSON value. Anything else JSON can't encode (only possible in an
+// untyped column, before it's flushed) falls back to its Go syntax.
+func primaryKeyValue(value any) string {
+	switch f := value.(type) {
+	case float64:
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case float32:
+		if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			return strconv.FormatFloat(float64(f), 'g', -1, 64)
+		}
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%#v", value)
+	}
+	return string(bytes)
+}
+
+// Returns the primary keys of the rows whose latest version matches.
+func (d *DeltaLakeClient) latestKeysMatching(
+	ctx context.Context, table string, predicate Predicate,
+) (map[string]struct{}, error) {
+	positions := primaryKeyPositions(d.tx.tables[table])
+	it, err := d.ScanWhere(ctx, table, predicate)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]struct{}{}
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return keys, nil
+		}
+		keys[primaryKeyOf(row, positions)] = struct{}{}
+	}
+}
@@ -1,96 +1,341 @@
 package deltalakeclient
 
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+)
+
 type scanIterator struct {
 	d     *DeltaLakeClient
 	table string
+	// From the Scan call, for reading dataobjects. Once it's done Next only returns its error.
+	ctx context.Context
 
 	// First we iterate through unflushed rows.
-	unflushedRows       [DATAOBJECT_SIZE][]any
-	unflushedRowsLen    int
+	unflushedRows       [][]any
 	unflushedRowPointer int
 
 	// Then we move through each dataobject.
-	allDataobjects        []string // Just the names, we fetch next one on calling next()
+	allDataobjects        []extantDataobject // We fetch the next one on calling next()
 	allDataobjectsPointer int
+	// The table's metadata as of the Scan call, which dataobjects are read in.
+	metadata *changeMetadataAction
+	// If the scan prefetches, it reads the dataobjects instead and we take them from it.
+	prefetcher *prefetcher
 
 	// And within each currentDataobject we iterate through rows.
 	currentDataobject        *dataobjectT // Content of current dataobject
 	currentDataObjectPointer int
+
+	// For tables with a primary key, where the primary key columns are and the keys we have already returned the
+	// latest version of.
+	primaryKeyPositions []int
+	seenKeys            map[string]struct{}
+
+	// See ScanOptions. Only rows matching `matches` (if it's set) are returned, with just the values at `projection`
+	// (if it's set), and no more than `limit` of them (if it's set).
+	matches    rowMatcher
+	projection []int
+	limit      int
+	returned   int
+
+	// Of the rows returned, i.e. after projection.
+	columns []Column
+}
+
+type ScanOptions struct {
+	// Names of the columns to return, in order. All of them if not set.
+	Columns []string
+	// Only rows matching this are returned, if it's set. It can use columns that aren't in Columns. Dataobjects whose
+	// stats show they have no matching rows aren't read.
+	Predicate Predicate
+	// The most rows to return, if it's positive. Dataobjects after the one with the last of them aren't read (apart from
+	// any already prefetched).
+	Limit int
+
+	// How many dataobjects to read ahead of the rows being returned, concurrently, if it's positive. Otherwise they're
+	// read one at a time as they're needed. If you stop calling Next before the end of a prefetching scan, call Close
+	// (or cancel its context) to stop the reads.
+	Prefetch int
+	// Roughly the most bytes of dataobjects (going by their size in storage) to prefetch at once, if it's positive. At
+	// least one dataobject is always read ahead, however big.
+	PrefetchBytes int
+	// Return the rows of each dataobject as soon as it's read, rather than in reverse-chronological order. Only makes
+	// a difference when prefetching, and is ignored for tables with a primary key, which need rows latest first.
+	Unordered bool
+}
+
+func (d *DeltaLakeClient) Scan(ctx context.Context, table string) (*scanIterator, error) {
+	return d.ScanWith(ctx, table, ScanOptions{})
+}
+
+// Like Scan, but only returns rows matching the predicate (every row, if it's nil).
+func (d *DeltaLakeClient) ScanWhere(ctx context.Context, table string, predicate Predicate) (*scanIterator, error) {
+	return d.ScanWith(ctx, table, ScanOptions{Predicate: predicate})
 }
 
-func (d *DeltaLakeClient) Scan(table string) (*scanIterator, error) {
+func (d *DeltaLakeClient) ScanWith(ctx context.Context, table string, options ScanOptions) (*scanIterator, error) {
 	if d.tx == nil {
 		return nil, errNoTx
 	}
 
-	// Unflushed rows
-	var unflushedRows [DATAOBJECT_SIZE][]any
-	if unflushedData, ok := d.tx.unflushedData[table]; ok {
-		unflushedRows = *unflushedData
+	metadata, ok := d.tx.tables[table]
+	if !ok {
+		return nil, errNoTable
+	}
+	predicate := options.Predicate
+	var bound boundPredicate
+	if predicate != nil {
+		var err error
+		bound, err = predicate.bind(metadata.Columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var projection []int
+	columns := metadata.Columns
+	if len(options.Columns) > 0 {
+		columns = nil
+	}
+	for _, name := range options.Columns {
+		i := columnIndex(metadata.Columns, name)
+		if i == -1 {
+			return nil, fmt.Errorf("%w: %s in table %s", errNoColumn, name, table)
+		}
+		projection = append(projection, i)
+		columns = append(columns, metadata.Columns[i])
 	}
-	unflushedRowsLen := d.tx.unflushedDataPointer[table]
+
+	d.tx.readTables[table] = struct{}{}
+
+	// Unflushed rows
+	// Copied, as deletes and updates change unflushed rows in place.
+	unflushedRows := slices.Clone(d.tx.unflushedData[table])
 
 	// Flushed
-	extantDataobjects := d.listExtantDataobjects(table)
-	extantDataobjectNames := make([]string, len(extantDataobjects))
-	for i, obj := range extantDataobjects {
-		extantDataobjectNames[i] = obj.Name
+	var extantDataobjects []extantDataobject
+	for _, object := range d.listExtantDataobjects(table) {
+		if predicate != nil && bound.canSkipDataobjects(metadata) && d.pruned(object, bound.mightMatch) {
+			continue
+		}
+		extantDataobjects = append(extantDataobjects, object)
 	}
 
-	return &scanIterator{
-		d:                d,
-		table:            table,
-		unflushedRows:    unflushedRows,
-		unflushedRowsLen: unflushedRowsLen,
+	si := &scanIterator{
+		d:             d,
+		table:         table,
+		ctx:           ctx,
+		unflushedRows: unflushedRows,
 		// To be reverse-chronological, we need to iterate backwards on unflushed data.
-		unflushedRowPointer:   unflushedRowsLen - 1,
-		allDataobjects:        extantDataobjectNames,
+		unflushedRowPointer:   len(unflushedRows) - 1,
+		allDataobjects:        extantDataobjects,
 		allDataobjectsPointer: len(extantDataobjects) - 1,
-	}, nil
+		metadata:              metadata,
+		primaryKeyPositions:   primaryKeyPositions(metadata),
+		seenKeys:              map[string]struct{}{},
+		matches:               bound.matches,
+		projection:            projection,
+		limit:                 options.Limit,
+		columns:               columns,
+	}
+	if options.Prefetch > 0 && len(extantDataobjects) > 0 {
+		unordered := options.Unordered && si.primaryKeyPositions == nil
+		si.prefetcher = newPrefetcher(
+			ctx, d, metadata, extantDataobjects, options.Prefetch, options.PrefetchBytes, unordered,
+		)
+	}
+	return si, nil
+}
+
+// Returns the rows where the column equals value, i.e. ScanWhere with ColumnEquals. Only dataobjects whose bloom filter
+// (and stats) for the column say they might have the value are read, so this is cheap for e.g. looking up a row by
+// its primary key.
+func (d *DeltaLakeClient) Lookup(ctx context.Context, table string, column string, value any) (*scanIterator, error) {
+	return d.ScanWhere(ctx, table, ColumnEquals(column, value))
 }
 
-// Iterates over the rows, in reverse-chronological order (i.e. latest version of rows will appear first).
+// Iterates over the rows, in reverse-chronological order (i.e. latest version of rows will appear first). For tables
+// with a primary key, only the latest version of each row is returned.
 func (si *scanIterator) Next() ([]any, error) {
-	// Unflushed rows first
-	// We have to loop here to find first non-nil row, as DeleteRows tombstones them to nil. We are also iterating
-	// backwards, as mentioned above.
-	for si.unflushedRowPointer >= 0 {
-		row := si.unflushedRows[si.unflushedRowPointer]
-		si.unflushedRowPointer--
-		if row != nil {
+	err := si.ctx.Err()
+	if err != nil {
+		si.Close()
+		return nil, err
+	}
+	if si.limit > 0 && si.returned >= si.limit {
+		// We won't need anything else that was prefetched.
+		si.Close()
+		return nil, nil
+	}
+
+	for {
+		row, err := si.nextLatestVersion()
+		if err != nil {
+			si.Close()
+			return nil, err
+		}
+		if row == nil {
+			return nil, nil
+		}
+
+		// Filter after deduplicating, an older version matching doesn't mean the row does.
+		if si.matches != nil {
+			r, err := si.matches(row)
+			if err != nil {
+				si.Close()
+				return nil, err
+			}
+			if !r {
+				continue
+			}
+		}
+
+		si.returned++
+		if si.projection == nil {
 			return row, nil
 		}
+		projected := make([]any, len(si.projection))
+		for i, position := range si.projection {
+			projected[i] = row[position]
+		}
+		return projected, nil
+	}
+}
+
+func (si *scanIterator) nextLatestVersion() ([]any, error) {
+	for {
+		row, err := si.nextVersion()
+		if err != nil || row == nil || si.primaryKeyPositions == nil {
+			return row, err
+		}
+
+		key := primaryKeyOf(row, si.primaryKeyPositions)
+		if _, seen := si.seenKeys[key]; !seen {
+			si.seenKeys[key] = struct{}{}
+			return row, nil
+		}
+	}
+}
+
+// Returns every version of every row.
+func (si *scanIterator) nextVersion() ([]any, error) {
+	// Unflushed rows first, iterating backwards, as mentioned above.
+	if si.unflushedRowPointer >= 0 {
+		row := si.unflushedRows[si.unflushedRowPointer]
+		si.unflushedRowPointer--
+		return row, nil
 	}
 
 	// Then flushed rows
+	for {
+		if si.currentDataobject == nil {
+			object, err := si.nextDataobject()
+			// If we are done with dataobjects, we're done overall.
+			if err != nil || object == nil {
+				return nil, err
+			}
+			si.currentDataobject = object
+			si.currentDataObjectPointer = len(object.Data) - 1
+		}
+
+		// Similar reason for for-loop as above.
+		for si.currentDataObjectPointer >= 0 {
+			row := si.currentDataobject.Data[si.currentDataObjectPointer]
+			si.currentDataObjectPointer--
+			if row != nil {
+				return row, nil
+			}
+		}
+
+		// Reached the end of the current dataobject, move on to the next (older) one.
+		si.currentDataobject = nil
+	}
+}
+
+// Returns the next dataobject to return rows from, or nil if there are none left.
+func (si *scanIterator) nextDataobject() (*dataobjectT, error) {
+	if si.prefetcher != nil {
+		return si.prefetcher.next()
+	}
 
-	// If we are done with dataobjects, we're done overall.
 	if si.allDataobjectsPointer < 0 {
 		return nil, nil
 	}
+	object := si.allDataobjects[si.allDataobjectsPointer]
+	si.allDataobjectsPointer--
+	return si.d.readDataobjectAs(si.ctx, si.metadata, object)
+}
 
-	if si.currentDataobject == nil {
-		object, err := si.d.readDataobject(si.table, si.allDataobjects[si.allDataobjectsPointer])
-		if err != nil {
-			return nil, err
-		}
-		si.currentDataobject = object
-		si.currentDataObjectPointer = object.Len - 1
+// Drops the rows the iterator is holding on to (and stops any prefetching), after which Next returns no more. Only
+// needed if you stop calling Next before the end or an error, e.g. Rows does it when the loop over it is broken out of.
+func (si *scanIterator) Close() {
+	si.unflushedRows, si.unflushedRowPointer = nil, -1
+	si.allDataobjects, si.allDataobjectsPointer = nil, -1
+	si.currentDataobject = nil
+	if si.prefetcher != nil {
+		si.prefetcher.close()
+		si.prefetcher = nil
 	}
+}
 
-	// Similar reason for for-loop as above.
-	for {
-		// If we have reached the end of the current dataobject, recurse to get next.
-		if si.currentDataObjectPointer < 0 {
-			si.currentDataobject = nil
-			si.allDataobjectsPointer--
-			return si.Next()
+// A row returned by Rows, which knows which columns its values are for.
+type Row struct {
+	Values []any
+	// The columns of the table (or just the ones asked for, with ScanOptions.Columns), in the order of Values.
+	columns []Column
+}
+
+// The value of the column with that name, and whether the row has that column at all.
+func (r Row) Get(column string) (any, bool) {
+	i := columnIndex(r.columns, column)
+	if i == -1 {
+		return nil, false
+	}
+	return r.Values[i], true
+}
+
+// The names of the row's columns, in the order of Values.
+func (r Row) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, column := range r.columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+// Like Scan, but for use with range:
+//
+//	for row, err := range client.Rows(ctx, "users") {
+//		...
+//	}
+//
+// Errors (including not being in a transaction or the table not existing) are yielded once, at which point the loop
+// ends. The scan starts when the loop does, so each loop over the same sequence scans again.
+func (d *DeltaLakeClient) Rows(ctx context.Context, table string) iter.Seq2[Row, error] {
+	return d.RowsWith(ctx, table, ScanOptions{})
+}
+
+// Like Rows, but with the options of ScanWith.
+func (d *DeltaLakeClient) RowsWith(ctx context.Context, table string, options ScanOptions) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		it, err := d.ScanWith(ctx, table, options)
+		if err != nil {
+			yield(Row{}, err)
+			return
 		}
+		defer it.Close()
 
-		row := si.currentDataobject.Data[si.currentDataObjectPointer]
-		si.currentDataObjectPointer--
-		if row != nil {
-			return row, nil
+		for {
+			values, err := it.Next()
+			if err != nil {
+				yield(Row{}, err)
+				return
+			}
+			if values == nil || !yield(Row{Values: values, columns: it.columns}, nil) {
+				return
+			}
 		}
 	}
 }
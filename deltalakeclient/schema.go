@@ -0,0 +1,329 @@
+package deltalakeclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"time"
+)
+
+type ColumnType string
+
+const (
+	// Untyped columns accept anything, and values go through JSON as-is (so numbers come back as float64). This is
+	// what CreateTable gives you.
+	TypeAny ColumnType = ""
+	// Go type: int64. Any integer type can be written.
+	TypeInt64 ColumnType = "int64"
+	// Go type: float64. Any integer or float type can be written.
+	TypeFloat64 ColumnType = "float64"
+	// Go type: string.
+	TypeString ColumnType = "string"
+	// Go type: bool.
+	TypeBool ColumnType = "bool"
+	// Go type: []byte.
+	TypeBytes ColumnType = "bytes"
+	// Go type: time.Time, always returned in UTC.
+	TypeTimestamp ColumnType = "timestamp"
+	// Go type: time.Time, truncated to midnight UTC of the date it had in its own location.
+	TypeDate ColumnType = "date"
+	// Go type: *big.Rat, so values are exact. Integer types can be written too.
+	TypeDecimal ColumnType = "decimal"
+)
+
+var columnTypes = []ColumnType{
+	TypeAny, TypeInt64, TypeFloat64, TypeString, TypeBool, TypeBytes, TypeTimestamp, TypeDate, TypeDecimal,
+}
+
+type Column struct {
+	Name     string
+	Type     ColumnType
+	Nullable bool
+	// Value of this column in rows written before it was added with AlterTable. Must be set for columns added to a
+	// table that aren't nullable.
+	Default any
+	// Assigned by the client when the column is created, and stays the same through renames and reorders. Dataobjects
+	// record the ids of the columns they were written with, so we can tell which of their values go where in the
+	// current schema.
+	Id int
+}
+
+// Tables created before columns had types stored just the column names.
+func (c *Column) UnmarshalJSON(b []byte) error {
+	var name string
+	if json.Unmarshal(b, &name) == nil {
+		*c = Column{Name: name, Type: TypeAny, Nullable: true}
+		return nil
+	}
+
+	type plainColumn Column
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err := decoder.Decode((*plainColumn)(c))
+	if err != nil {
+		return err
+	}
+	c.Default, err = decodeJSONValue(*c, c.Default)
+	return err
+}
+
+type Schema struct {
+	Columns []Column
+	// Optional, names of the (non-nullable) columns that identify a row. Writing a row with the same key as an
+	// existing one replaces it, see Upsert.
+	PrimaryKey []string
+	// Optional, CodecColumnar if not set.
+	Codec Codec
+}
+
+func (s Schema) validate() error {
+	if len(s.Columns) == 0 {
+		return fmt.Errorf("%w: no columns", errInvalidSchema)
+	}
+
+	seen := map[string]struct{}{}
+	for _, column := range s.Columns {
+		if column.Name == "" {
+			return fmt.Errorf("%w: column with no name", errInvalidSchema)
+		}
+		if _, ok := seen[column.Name]; ok {
+			return fmt.Errorf("%w: duplicate column %s", errInvalidSchema, column.Name)
+		}
+		seen[column.Name] = struct{}{}
+
+		if !isColumnType(column.Type) {
+			return fmt.Errorf("%w: column %s has unknown type %q", errInvalidSchema, column.Name, column.Type)
+		}
+	}
+
+	if _, ok := codecs[s.Codec]; !ok && s.Codec != "" {
+		return fmt.Errorf("%w: unknown codec %q", errInvalidSchema, s.Codec)
+	}
+
+	for i, name := range s.PrimaryKey {
+		index := columnIndex(s.Columns, name)
+		if index == -1 || slices.Index(s.PrimaryKey, name) != i {
+			return fmt.Errorf("%w: invalid primary key column %s", errInvalidSchema, name)
+		}
+		if s.Columns[index].Nullable {
+			return fmt.Errorf("%w: primary key column %s is nullable", errInvalidSchema, name)
+		}
+	}
+	return nil
+}
+
+func isColumnType(columnType ColumnType) bool {
+	for _, t := range columnTypes {
+		if t == columnType {
+			return true
+		}
+	}
+	return false
+}
+
+func columnIds(columns []Column) []int {
+	ids := make([]int, len(columns))
+	for i, column := range columns {
+		ids[i] = column.Id
+	}
+	return ids
+}
+
+// For each of `columns`, returns the index of the value for it in a row written with the column ids `fromIds`, or -1 if
+// that row won't have a value for it. Rows written before columns had ids (nil fromIds) just have them in order.
+func columnPositions(fromIds []int, columns []Column) []int {
+	positions := make([]int, len(columns))
+	for i, column := range columns {
+		if fromIds == nil {
+			positions[i] = column.Id - 1
+		} else {
+			positions[i] = slices.Index(fromIds, column.Id)
+		}
+	}
+	return positions
+}
+
+// Rearranges a row written with an older schema onto `columns`, filling in defaults for columns it didn't have.
+func projectRow(row []any, positions []int, columns []Column) []any {
+	projected := make([]any, len(columns))
+	for i, position := range positions {
+		if position < 0 || position >= len(row) {
+			projected[i] = columns[i].Default
+		} else {
+			projected[i] = row[position]
+		}
+	}
+	return projected
+}
+
+func columnIndex(columns []Column, name string) int {
+	for i, column := range columns {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Checks a row being written matches the columns, and returns a copy with each value converted to the Go type of its
+// column (e.g. an int written to an int64 column becomes an int64).
+func validateRow(columns []Column, row []any) ([]any, error) {
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("%w: expected %d columns, got %d", errTypeMismatch, len(columns), len(row))
+	}
+
+	validated := make([]any, len(row))
+	for i, column := range columns {
+		value, err := validateValue(column, row[i])
+		if err != nil {
+			return nil, err
+		}
+		validated[i] = value
+	}
+	return validated, nil
+}
+
+func validateValue(column Column, value any) (any, error) {
+	if value == nil {
+		if !column.Nullable {
+			return nil, fmt.Errorf("%w: column %s is not nullable", errTypeMismatch, column.Name)
+		}
+		return nil, nil
+	}
+
+	converted, ok := convertValue(column.Type, value)
+	if !ok {
+		return nil, fmt.Errorf(
+			"%w: column %s has type %s, got %v (%T)", errTypeMismatch, column.Name, column.Type, value, value,
+		)
+	}
+	return converted, nil
+}
+
+func convertValue(columnType ColumnType, value any) (any, bool) {
+	switch columnType {
+	case TypeAny:
+		return value, true
+	case TypeInt64:
+		i, ok := asInt64(value)
+		return i, ok
+	case TypeFloat64:
+		if f, ok := value.(float32); ok {
+			return float64(f), true
+		}
+		if f, ok := value.(float64); ok {
+			return f, true
+		}
+		i, ok := asInt64(value)
+		if !ok {
+			return nil, false
+		}
+		return float64(i), true
+	case TypeString:
+		s, ok := value.(string)
+		return s, ok
+	case TypeBool:
+		b, ok := value.(bool)
+		return b, ok
+	case TypeBytes:
+		b, ok := value.([]byte)
+		return b, ok
+	case TypeTimestamp:
+		t, ok := value.(time.Time)
+		return t.UTC(), ok
+	case TypeDate:
+		t, ok := value.(time.Time)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), ok
+	case TypeDecimal:
+		if r, ok := value.(*big.Rat); ok && r != nil {
+			return r, true
+		}
+		i, ok := asInt64(value)
+		if !ok {
+			return nil, false
+		}
+		return new(big.Rat).SetInt64(i), true
+	default:
+		return nil, false
+	}
+}
+
+func asInt64(value any) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	default:
+		return 0, false
+	}
+}
+
+// Converts a value decoded from JSON (with json.Decoder.UseNumber) back to the Go type of its column.
+func decodeJSONValue(column Column, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch column.Type {
+	case TypeAny:
+		return fromJSONNumbers(value), nil
+	case TypeInt64:
+		if n, ok := value.(json.Number); ok {
+			return n.Int64()
+		}
+	case TypeFloat64:
+		if n, ok := value.(json.Number); ok {
+			return n.Float64()
+		}
+	case TypeString, TypeBool:
+		return value, nil
+	case TypeBytes:
+		if s, ok := value.(string); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	case TypeTimestamp, TypeDate:
+		if s, ok := value.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, err
+			}
+			return t.UTC(), nil
+		}
+	case TypeDecimal:
+		if s, ok := value.(string); ok {
+			r, ok := new(big.Rat).SetString(s)
+			if ok {
+				return r, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: could not decode %v as %s for column %s", errTypeMismatch, value, column.Type, column.Name)
+}
+
+// Untyped values should look like they did before we decoded with UseNumber, i.e. numbers are float64s.
+func fromJSONNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i := range v {
+			v[i] = fromJSONNumbers(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = fromJSONNumbers(v[k])
+		}
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package deltalakeclient
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Summary of the rows in a dataobject, recorded in its AddDataobject action so readers looking for particular rows can
+// skip dataobjects that can't have any.
+type dataobjectStats struct {
+	Rows    int
+	Columns []columnStats
+}
+
+type columnStats struct {
+	Id    int
+	Nulls int
+	// The smallest and largest values, encoded like values in JSON dataobjects. Missing if the values can't be ordered
+	// (e.g. bools, or a mix of strings and numbers in an untyped column), or are all null.
+	Min, Max json.RawMessage
+	// Of the valueKeys of the values, see newBloomFilter. Missing if the values are all null or any can't be keyed.
+	BloomFilter []byte
+}
+
+// Stats of a dataobject holding rows in the columns' schema.
+func computeStats(columns []Column, rows [][]any) *dataobjectStats {
+	stats := &dataobjectStats{Rows: len(rows)}
+	for i, column := range columns {
+		columnStats := columnStats{Id: column.Id}
+		var min, max any
+		ordered := true
+		keys, keyed := map[string]struct{}{}, true
+		for _, row := range rows {
+			value := row[i]
+			if value == nil {
+				columnStats.Nulls++
+				continue
+			}
+
+			if keyed {
+				var key string
+				key, keyed = valueKey(value)
+				keys[key] = struct{}{}
+			}
+			if !ordered {
+				continue
+			}
+			if min == nil {
+				min, max = value, value
+			}
+			c1, ok1 := compareValues(value, min)
+			c2, ok2 := compareValues(value, max)
+			ordered = ok1 && ok2
+			if c1 < 0 {
+				min = value
+			}
+			if c2 > 0 {
+				max = value
+			}
+		}
+
+		if ordered && min != nil {
+			// Can only fail for values that couldn't be written to a JSON dataobject either, in which case we just don't
+			// know the bounds.
+			minBytes, err1 := json.Marshal(min)
+			maxBytes, err2 := json.Marshal(max)
+			if err1 == nil && err2 == nil {
+				columnStats.Min, columnStats.Max = minBytes, maxBytes
+			}
+		}
+		if keyed && len(keys) > 0 {
+			columnStats.BloomFilter = newBloomFilter(keys)
+		}
+		stats.Columns = append(stats.Columns, columnStats)
+	}
+	return stats
+}
+
+// Stats for the column, nil if the dataobject was written before it was added.
+func (s *dataobjectStats) column(id int) *columnStats {
+	i := slices.IndexFunc(s.Columns, func(c columnStats) bool { return c.Id == id })
+	if i == -1 {
+		return nil
+	}
+	return &s.Columns[i]
+}
+
+// The smallest and largest values of the column, as the Go type of the column. ok is false if they aren't known.
+func (s *columnStats) bounds(column Column) (min any, max any, ok bool) {
+	if s.Min == nil || s.Max == nil {
+		return nil, nil, false
+	}
+	min, err1 := decodeStatsValue(column, s.Min)
+	max, err2 := decodeStatsValue(column, s.Max)
+	return min, max, err1 == nil && err2 == nil && min != nil && max != nil
+}
+
+func decodeStatsValue(column Column, data json.RawMessage) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONValue(column, value)
+}
+
+// Orders two values of the same kind, ok is false if they can't be ordered (e.g. a string and a number, or NaN).
+func compareValues(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		return strings.Compare(a, b), ok
+	case time.Time:
+		b, ok := b.(time.Time)
+		return a.Compare(b), ok
+	case *big.Rat:
+		b, ok := b.(*big.Rat)
+		if !ok {
+			return 0, false
+		}
+		return a.Cmp(b), true
+	}
+
+	// Otherwise they need to both be numbers. Compare integers as integers, so large int64s don't lose precision.
+	aInt, ok1 := asInt64(a)
+	bInt, ok2 := asInt64(b)
+	if ok1 && ok2 {
+		return cmp.Compare(aInt, bInt), true
+	}
+	aFloat, ok1 := asFloat64(a)
+	bFloat, ok2 := asFloat64(b)
+	if !ok1 || !ok2 || math.IsNaN(aFloat) || math.IsNaN(bFloat) {
+		return 0, false
+	}
+	return cmp.Compare(aFloat, bFloat), true
+}
+
+func asFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	i, ok := asInt64(value)
+	return float64(i), ok
+}
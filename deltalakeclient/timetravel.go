@@ -0,0 +1,95 @@
+package deltalakeclient
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Starts a read-only transaction that sees the tables exactly as they were after transaction `version` committed.
+func (d *DeltaLakeClient) NewTxAsOfVersion(ctx context.Context, version int) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+	if version < 0 {
+		return errNoVersion
+	}
+
+	_, err := d.os.Stat(ctx, logFilename(version))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errNoVersion
+	} else if err != nil {
+		return err
+	}
+
+	return d.newReadOnlyTx(ctx, version)
+}
+
+// Starts a read-only transaction that sees the tables as they were at time t, i.e. as of the last transaction
+// committed at or before t.
+func (d *DeltaLakeClient) NewTxAsOfTime(ctx context.Context, t time.Time) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+
+	txLogFilenames, err := d.os.ListPrefixOrdered(ctx, "_log_")
+	if err != nil {
+		return err
+	}
+
+	// Commit timestamps only go up (as long as the committers' clocks roughly agree), so we can binary search for the
+	// first transaction committed after t rather than reading every log file.
+	var searchErr error
+	i := sort.Search(len(txLogFilenames), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		timestamp, err := d.logTimestamp(ctx, txLogFilenames[i])
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return timestamp.After(t)
+	})
+	if searchErr != nil {
+		return searchErr
+	}
+	if i == 0 {
+		return errNoVersion
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(txLogFilenames[i-1], "_log_"))
+	if err != nil {
+		return err
+	}
+	return d.newReadOnlyTx(ctx, version)
+}
+
+func (d *DeltaLakeClient) newReadOnlyTx(ctx context.Context, version int) error {
+	tx, err := d.readSnapshot(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	tx.readOnly = true
+	d.tx = tx
+	return nil
+}
+
+// Older log files don't have a timestamp, so fall back to when the file was written.
+func (d *DeltaLakeClient) logTimestamp(ctx context.Context, txLogFilename string) (time.Time, error) {
+	oldTx, err := d.readLog(ctx, txLogFilename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !oldTx.Timestamp.IsZero() {
+		return oldTx.Timestamp, nil
+	}
+
+	info, err := d.os.Stat(ctx, txLogFilename)
+	return info.ModTime, err
+}
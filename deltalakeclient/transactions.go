@@ -1,8 +1,17 @@
 package deltalakeclient
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rptynan/delta-lake/objectstorage"
+	"github.com/rptynan/delta-lake/utils"
 )
 
 type dataobjectActionT struct {
@@ -14,11 +23,56 @@ type dataobjectActionT struct {
 	// This is used to preserve order of rows after we do copy-on-writes for deletes. I'm not sure if
 	// this how delta lake does it, can't find much easily online.
 	TxId int
+	// Orders dataobjects with the same TxId, i.e. the order they were flushed in within that transaction. Like TxId,
+	// this is kept by copy-on-writes, so rewritten rows stay in place relative to the other rows of the transaction.
+	Seq int
+
+	// Only set for Parquet files that weren't written by us, see RegisterParquetFile. They live at Path rather than
+	// being named after the dataobject, and their columns are the table's columns with ids Columns, in order.
+	Path    string
+	Columns []int
+
+	// Nil for dataobjects written before we kept stats.
+	Stats *dataobjectStats
 }
 
+func (a *dataobjectActionT) filename() string {
+	if a.Path != "" {
+		return a.Path
+	}
+	return dataobjectFilename(a.Table, a.Name)
+}
+
+// Holds the whole (latest) definition of the table, not just what changed.
 type changeMetadataAction struct {
 	Table   string
-	Columns []string
+	Columns []Column
+	// The largest column id ever used in this table, so ids of dropped columns aren't reused.
+	MaxColumnId int
+	// Ids of the columns making up the primary key, if the table has one.
+	PrimaryKey []int
+	// How the table's dataobjects are serialised.
+	Codec Codec
+}
+
+// Tables created before columns had ids used their position, and before codecs they were all JSON.
+func (m *changeMetadataAction) UnmarshalJSON(b []byte) error {
+	type plainMetadata changeMetadataAction
+	err := json.Unmarshal(b, (*plainMetadata)(m))
+	if err != nil {
+		return err
+	}
+
+	if m.MaxColumnId == 0 {
+		for i := range m.Columns {
+			m.Columns[i].Id = i + 1
+		}
+		m.MaxColumnId = len(m.Columns)
+	}
+	if m.Codec == "" {
+		m.Codec = CodecJSON
+	}
+	return nil
 }
 
 // an enum, only one field will be non-nil
@@ -26,10 +80,21 @@ type Action struct {
 	AddDataobject    *dataobjectActionT
 	DeleteDataobject *dataobjectActionT
 	ChangeMetadata   *changeMetadataAction
+	DeletionVector   *deletionVectorAction
 }
 
 type transaction struct {
 	Id int
+	// When the transaction was committed, according to the committer's clock. Zero for transactions committed before
+	// this was recorded.
+	Timestamp time.Time
+
+	// Set for transactions reading an older version of the log, which can't be written to.
+	readOnly bool
+
+	// Tables whose contents this transaction depended on (i.e. scanned or deleted from), used for conflict detection
+	// on commit.
+	readTables map[string]struct{}
 
 	// Both below are mapping table name to a list of actions on the table.
 	// previousActions is a populated (when we start a new transaction) by reading
@@ -38,82 +103,185 @@ type transaction struct {
 	// Actions is the set of actions for the current transaction before commit.
 	Actions map[string][]Action
 
-	// Mapping tables to column names.
+	// Mapping tables to their latest metadata.
 	// Add ChangeMetadataActions in either previousActions or Actions, will cause
-	// the columns here to be updated.
-	tables map[string][]string
+	// the metadata here to be updated.
+	tables map[string]*changeMetadataAction
 
 	// Mapping table name to unflushed/in-memory rows. When rows are flushed, the
 	// dataobject that contains them is added to `tx.actions` above and
-	// `tx.unflushedDataPointer[table]` is reset to `0`.
-	unflushedData        map[string]*[DATAOBJECT_SIZE][]any
-	unflushedDataPointer map[string]int
+	// `tx.unflushedData[table]` is emptied.
+	unflushedData map[string][][]any
+	// Roughly how big the unflushed rows of each table are, see rowSize.
+	unflushedBytes map[string]int
+}
+
+func newTransaction() *transaction {
+	tx := &transaction{}
+	tx.previousActions = map[string][]Action{}
+	tx.Actions = map[string][]Action{}
+	tx.tables = map[string]*changeMetadataAction{}
+	tx.unflushedData = map[string][][]any{}
+	tx.unflushedBytes = map[string]int{}
+	tx.readTables = map[string]struct{}{}
+	return tx
 }
 
-func (d *DeltaLakeClient) NewTx() error {
+func (d *DeltaLakeClient) NewTx(ctx context.Context) error {
 	if d.tx != nil {
 		return errExistingTx
 	}
 
-	logPrefix := "_log_"
-	txLogFilenames, err := d.os.ListPrefixOrdered(logPrefix)
+	tx, err := d.readSnapshot(ctx, -1)
 	if err != nil {
 		return err
 	}
 
-	tx := &transaction{}
-	tx.previousActions = map[string][]Action{}
-	tx.Actions = map[string][]Action{}
-	tx.tables = map[string][]string{}
-	tx.unflushedData = map[string]*[DATAOBJECT_SIZE][]any{}
-	tx.unflushedDataPointer = map[string]int{}
+	d.tx = tx
+	return nil
+}
 
-	for _, txLogFilename := range txLogFilenames {
-		bytes, err := d.os.Read(txLogFilename)
+// Reconstructs the state of the log up to and including transaction `version` (or all of it, if version is negative)
+// into a new transaction.
+func (d *DeltaLakeClient) readSnapshot(ctx context.Context, version int) (*transaction, error) {
+	tx := newTransaction()
+	logPrefix := "_log_"
+	replayFrom := ""
+
+	// For the latest version, start from the latest snapshot any of our transactions read, or otherwise the latest
+	// checkpoint (if there is one), so we only need to replay the log files after it.
+	var cached *transaction
+	if version < 0 {
+		cached = d.snapshots.get()
+	}
+	if cached != nil {
+		tx = cached
+		replayFrom = logFilename(tx.Id)
+	} else {
+		checkpoint, err := d.readLatestCheckpoint(ctx, version)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if checkpoint != nil {
+			tx.Id = checkpoint.Id + 1
+			tx.previousActions = checkpoint.Actions
+			tx.tables = checkpoint.Tables
+			replayFrom = logFilename(tx.Id)
+		}
+	}
+
+	txLogFilenames, err := d.os.ListPrefixOrdered(ctx, logPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, txLogFilename := range txLogFilenames {
+		// Log filenames are zero-padded, so comparing them as strings compares the ids.
+		if txLogFilename < replayFrom {
+			continue
+		}
+		if version >= 0 && txLogFilename > logFilename(version) {
+			break
 		}
 
-		var oldTx transaction
-		err = json.Unmarshal(bytes, &oldTx)
+		oldTx, err := d.readLog(ctx, txLogFilename)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// Transaction metadata files are sorted lexicographically so that the most recent transaction
-		// (i.e. the one with the largest transaction id) will be last and tx.Id will end up 1 greater
-		// than the most recent transaction ID we see on disk.
-		tx.Id = oldTx.Id + 1
-
-		for table, actions := range oldTx.Actions {
-			for _, action := range actions {
-				if action.AddDataobject != nil || action.DeleteDataobject != nil {
-					tx.previousActions[table] = append(tx.previousActions[table], action)
-				} else if action.ChangeMetadata != nil {
-					// Store the latest version of each table in memory for easy lookup.
-					mtd := action.ChangeMetadata
-					tx.tables[table] = mtd.Columns
-				} else {
-					panic(fmt.Sprintf("unsupported action: %v", action))
-				}
+		tx.replay(oldTx)
+	}
+
+	if version < 0 {
+		d.snapshots.put(tx)
+	}
+	return tx, nil
+}
+
+// The latest snapshot of the log read by a client (or any of its Txs), shared between them. What's cached is never
+// changed, transactions get their own copy to replay more of the log onto.
+type snapshotCache struct {
+	mu sync.Mutex
+	tx *transaction
+}
+
+// A new transaction starting from the cached snapshot, or nil if there isn't one.
+func (c *snapshotCache) get() *transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tx == nil {
+		return nil
+	}
+	return c.tx.cloneSnapshot()
+}
+
+func (c *snapshotCache) put(tx *transaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tx == nil || tx.Id > c.tx.Id {
+		c.tx = tx.cloneSnapshot()
+	}
+}
+
+// Copies what was read from the log into a new transaction. The actions and metadata themselves are never changed once
+// committed, so they're shared, but the slices are clipped so appending to one copy's doesn't write into another's.
+func (tx *transaction) cloneSnapshot() *transaction {
+	clone := newTransaction()
+	clone.Id = tx.Id
+	for table, actions := range tx.previousActions {
+		clone.previousActions[table] = slices.Clip(actions)
+	}
+	maps.Copy(clone.tables, tx.tables)
+	return clone
+}
+
+func (d *DeltaLakeClient) readLog(ctx context.Context, txLogFilename string) (*transaction, error) {
+	bytes, err := d.os.Read(ctx, txLogFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	var oldTx transaction
+	err = json.Unmarshal(bytes, &oldTx)
+	return &oldTx, err
+}
+
+// Applies a committed transaction read from the log on top of this one.
+func (tx *transaction) replay(oldTx *transaction) {
+	// Transaction metadata files are sorted lexicographically so that the most recent transaction
+	// (i.e. the one with the largest transaction id) will be last and tx.Id will end up 1 greater
+	// than the most recent transaction ID we see on disk.
+	tx.Id = oldTx.Id + 1
+
+	for table, actions := range oldTx.Actions {
+		for _, action := range actions {
+			if action.AddDataobject != nil || action.DeleteDataobject != nil || action.DeletionVector != nil {
+				tx.previousActions[table] = append(tx.previousActions[table], action)
+			} else if action.ChangeMetadata != nil {
+				// Store the latest version of each table in memory for easy lookup.
+				tx.tables[table] = action.ChangeMetadata
+			} else {
+				panic(fmt.Sprintf("unsupported action: %v", action))
 			}
 		}
 	}
+}
 
-	d.tx = tx
-	return nil
+func logFilename(txId int) string {
+	return fmt.Sprintf("_log_%020d", txId)
 }
 
-func (d *DeltaLakeClient) CommitTx() error {
+// If ctx is done before the log entry is written, the transaction is aborted. The log entry is written in one go, so
+// it's never left half-written, but if ctx is done while it's being written we can't tell whether it was.
+func (d *DeltaLakeClient) CommitTx(ctx context.Context) error {
 	if d.tx == nil {
 		return errNoTx
 	}
 
 	// Flush any outstanding data
 	for table := range d.tx.tables {
-		err := d.flushRows(table)
+		err := d.flushRows(ctx, table)
 		if err != nil {
-			d.tx = nil
-			return err
+			return d.abortTx(ctx, err)
 		}
 	}
 
@@ -130,37 +298,116 @@ func (d *DeltaLakeClient) CommitTx() error {
 		return nil
 	}
 
-	filename := fmt.Sprintf("_log_%020d", d.tx.Id)
-	// We won't store previous actions, they will be recovered on
-	// new transactions. So unset them. Honestly not totally
-	// clear why.
-	d.tx.previousActions = nil
-	bytes, err := json.Marshal(d.tx)
-	if err != nil {
-		d.tx = nil
-		return err
+	for attempt := 0; ; attempt++ {
+		d.tx.Timestamp = time.Now()
+		// We won't store previous actions (they're unexported, so not serialised), they will be recovered on new
+		// transactions.
+		bytes, err := json.Marshal(d.tx)
+		if err == nil {
+			// Last point at which we know the log entry won't be written, and can still clean up.
+			err = ctx.Err()
+		}
+		if err != nil {
+			return d.abortTx(ctx, err)
+		}
+
+		err = d.os.PutIfAbsent(ctx, logFilename(d.tx.Id), bytes)
+		if err == nil {
+			break
+		}
+		// For any other error, we don't know whether the log entry was written or not (e.g. the response was lost),
+		// so we have to leave our dataobjects alone in case it was.
+		if !errors.Is(err, objectstorage.ErrObjectExists) && !errors.Is(err, objectstorage.ErrWriteConflict) {
+			d.tx = nil
+			return err
+		}
+		// Someone else committed first (or was committing at the same time, and may have). If what they did doesn't
+		// affect us, we can move our transaction after theirs and try again. If they didn't commit after all, there's
+		// nothing to move after, and we just try again.
+		if attempt >= d.commitRetries {
+			return d.abortTx(ctx, err)
+		}
+		err = d.rebase(ctx, d.tx)
+		if err != nil {
+			return d.abortTx(ctx, err)
+		}
+	}
+
+	d.maybeWriteCheckpoint(ctx, d.tx)
+	d.tx = nil
+	return nil
+}
+
+// Abandons the current transaction, discarding its actions and unflushed rows. Any dataobjects it already flushed are
+// deleted, since nothing else can reference them.
+func (d *DeltaLakeClient) RollbackTx(ctx context.Context) error {
+	if d.tx == nil {
+		return errNoTx
 	}
 
-	err = d.os.PutIfAbsent(filename, bytes)
+	tx := d.tx
 	d.tx = nil
+	return d.deleteWrittenDataobjects(ctx, tx)
+}
+
+// For when a commit definitely failed, cleans up like RollbackTx and returns the original error. That includes when
+// ctx is done, so the cleanup ignores its cancellation.
+func (d *DeltaLakeClient) abortTx(ctx context.Context, err error) error {
+	tx := d.tx
+	d.tx = nil
+	cleanupErr := d.deleteWrittenDataobjects(context.WithoutCancel(ctx), tx)
+	if cleanupErr != nil {
+		// Not much we can do, Vacuum will get them eventually.
+		utils.Debug("could not clean up aborted transaction", tx.Id, cleanupErr)
+	}
 	return err
 }
 
-func (d *DeltaLakeClient) flushRows(table string) error {
+// Every AddDataobject in a transaction is for a dataobject it wrote itself (whether flushed or rewritten), except for
+// registered files, which aren't ours to delete.
+func (d *DeltaLakeClient) deleteWrittenDataobjects(ctx context.Context, tx *transaction) error {
+	var errs []error
+	for table, actions := range tx.Actions {
+		for _, action := range actions {
+			if action.AddDataobject != nil && action.AddDataobject.Path == "" {
+				err := d.os.Delete(ctx, dataobjectFilename(table, action.AddDataobject.Name))
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// The Seq for the next dataobject this transaction adds to the table. Every dataobject this transaction has added so
+// far has a different Seq (rewrites keep the Seq of what they replaced), so counting them gives us one higher than any
+// so far.
+func (d *DeltaLakeClient) nextSeq(table string) int {
+	seq := 0
+	for _, action := range d.tx.Actions[table] {
+		if action.AddDataobject != nil && action.AddDataobject.TxId == d.tx.Id {
+			seq++
+		}
+	}
+	return seq
+}
+
+func (d *DeltaLakeClient) flushRows(ctx context.Context, table string) error {
 	// Early return if there's no unflushed data
-	pointer, ok := d.tx.unflushedDataPointer[table]
-	if !ok || pointer == 0 {
+	if len(d.tx.unflushedData[table]) == 0 {
 		return nil
 	}
 
-	addDataobjectAction, err := d.writeDataObject(table, d.tx.unflushedData[table], d.tx.Id)
+	addDataobjectAction, err := d.writeDataObject(ctx, table, d.tx.unflushedData[table], d.tx.Id, d.nextSeq(table))
 	if err != nil {
 		return err
 	}
 
 	d.tx.Actions[table] = append(d.tx.Actions[table], addDataobjectAction)
 
-	// Don't forget to reset pointer
-	d.tx.unflushedDataPointer[table] = 0
+	// Don't forget to reset the rows, otherwise the next flush would write these again along with the new ones.
+	d.tx.unflushedData[table] = nil
+	d.tx.unflushedBytes[table] = 0
 	return nil
 }
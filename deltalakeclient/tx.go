@@ -0,0 +1,116 @@
+package deltalakeclient
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// A transaction started with Begin. Unlike the client's own transaction (NewTx etc), any number of these can be open at
+// once on the same client, from different goroutines, e.g. one per request. A Tx itself must only be used by one
+// goroutine at a time though.
+type Tx struct {
+	// A copy of the client with its own current transaction, so the client's methods work on it as usual. Everything
+	// that isn't per-transaction (storage, options, metrics and the snapshot cache) is shared with the client.
+	d DeltaLakeClient
+}
+
+// Starts a transaction on the latest version of the log, independent of the client's own transaction and any other
+// Txs. The log files read to get there are cached on the client, so each Begin only reads the ones committed since.
+func (d *DeltaLakeClient) Begin(ctx context.Context) (*Tx, error) {
+	return d.begin(func(d *DeltaLakeClient) error { return d.NewTx(ctx) })
+}
+
+// Like Begin, but read-only at an older version, see NewTxAsOfVersion.
+func (d *DeltaLakeClient) BeginAsOfVersion(ctx context.Context, version int) (*Tx, error) {
+	return d.begin(func(d *DeltaLakeClient) error { return d.NewTxAsOfVersion(ctx, version) })
+}
+
+// Like Begin, but read-only as of a time, see NewTxAsOfTime.
+func (d *DeltaLakeClient) BeginAsOfTime(ctx context.Context, t time.Time) (*Tx, error) {
+	return d.begin(func(d *DeltaLakeClient) error { return d.NewTxAsOfTime(ctx, t) })
+}
+
+// Only reads the fields that are never changed after NewClient, so it's safe alongside the client's own transaction.
+func (d *DeltaLakeClient) begin(newTx func(*DeltaLakeClient) error) (*Tx, error) {
+	t := &Tx{d: DeltaLakeClient{
+		os:                 d.os,
+		checkpointInterval: d.checkpointInterval,
+		commitRetries:      d.commitRetries,
+		maxDataobjectRows:  d.maxDataobjectRows,
+		maxDataobjectBytes: d.maxDataobjectBytes,
+		metrics:            d.metrics,
+		snapshots:          d.snapshots,
+	}}
+	err := newTx(&t.d)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Once either of these returns, the Tx is finished and everything else on it returns errNoTx.
+
+func (t *Tx) Commit(ctx context.Context) error {
+	return t.d.CommitTx(ctx)
+}
+
+func (t *Tx) Rollback(ctx context.Context) error {
+	return t.d.RollbackTx(ctx)
+}
+
+func (t *Tx) CreateTable(table string, columns []string) error {
+	return t.d.CreateTable(table, columns)
+}
+
+func (t *Tx) CreateTableWithSchema(table string, schema Schema) error {
+	return t.d.CreateTableWithSchema(table, schema)
+}
+
+func (t *Tx) AlterTable(table string, ops ...AlterTableOp) error {
+	return t.d.AlterTable(table, ops...)
+}
+
+func (t *Tx) RegisterParquetFile(ctx context.Context, table string, path string) error {
+	return t.d.RegisterParquetFile(ctx, table, path)
+}
+
+func (t *Tx) WriteRow(ctx context.Context, table string, row []any) error {
+	return t.d.WriteRow(ctx, table, row)
+}
+
+func (t *Tx) Upsert(ctx context.Context, table string, row []any) error {
+	return t.d.Upsert(ctx, table, row)
+}
+
+func (t *Tx) DeleteRows(ctx context.Context, table string, predicate Predicate) error {
+	return t.d.DeleteRows(ctx, table, predicate)
+}
+
+func (t *Tx) UpdateRows(ctx context.Context, table string, predicate Predicate, assignments map[string]any) error {
+	return t.d.UpdateRows(ctx, table, predicate, assignments)
+}
+
+func (t *Tx) Scan(ctx context.Context, table string) (*scanIterator, error) {
+	return t.d.Scan(ctx, table)
+}
+
+func (t *Tx) ScanWhere(ctx context.Context, table string, predicate Predicate) (*scanIterator, error) {
+	return t.d.ScanWhere(ctx, table, predicate)
+}
+
+func (t *Tx) ScanWith(ctx context.Context, table string, options ScanOptions) (*scanIterator, error) {
+	return t.d.ScanWith(ctx, table, options)
+}
+
+func (t *Tx) Lookup(ctx context.Context, table string, column string, value any) (*scanIterator, error) {
+	return t.d.Lookup(ctx, table, column, value)
+}
+
+func (t *Tx) Rows(ctx context.Context, table string) iter.Seq2[Row, error] {
+	return t.d.Rows(ctx, table)
+}
+
+func (t *Tx) RowsWith(ctx context.Context, table string, options ScanOptions) iter.Seq2[Row, error] {
+	return t.d.RowsWith(ctx, table, options)
+}
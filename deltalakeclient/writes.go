@@ -1,161 +1,321 @@
 package deltalakeclient
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"slices"
-
-	"github.com/rptynan/delta-lake/utils"
+	"time"
 )
 
+// Creates a table with untyped (TypeAny), nullable columns.
 func (d *DeltaLakeClient) CreateTable(table string, columns []string) error {
+	schema := Schema{}
+	for _, name := range columns {
+		schema.Columns = append(schema.Columns, Column{Name: name, Type: TypeAny, Nullable: true})
+	}
+	return d.CreateTableWithSchema(table, schema)
+}
+
+func (d *DeltaLakeClient) CreateTableWithSchema(table string, schema Schema) error {
 	if d.tx == nil {
 		return errNoTx
 	}
+	if d.tx.readOnly {
+		return errReadOnlyTx
+	}
 
 	if _, exists := d.tx.tables[table]; exists {
 		return errTableExists
 	}
+	err := schema.validate()
+	if err != nil {
+		return err
+	}
+
+	metadata := &changeMetadataAction{
+		Table:   table,
+		Columns: slices.Clone(schema.Columns),
+		Codec:   schema.Codec,
+	}
+	if metadata.Codec == "" {
+		metadata.Codec = CodecColumnar
+	}
+	for i := range metadata.Columns {
+		metadata.MaxColumnId++
+		metadata.Columns[i].Id = metadata.MaxColumnId
+	}
+	for _, name := range schema.PrimaryKey {
+		metadata.PrimaryKey = append(metadata.PrimaryKey, metadata.Columns[columnIndex(metadata.Columns, name)].Id)
+	}
 
 	// Store it in the in-memory mapping.
-	d.tx.tables[table] = columns
+	d.tx.tables[table] = metadata
 
 	// And also add it to the action history for future transactions.
 	d.tx.Actions[table] = append(d.tx.Actions[table], Action{
-		ChangeMetadata: &changeMetadataAction{
-			Table:   table,
-			Columns: columns,
-		},
+		ChangeMetadata: metadata,
 	})
 
 	return nil
 }
 
-func (d *DeltaLakeClient) WriteRow(table string, row []any) error {
+func (d *DeltaLakeClient) WriteRow(ctx context.Context, table string, row []any) error {
 	if d.tx == nil {
 		return errNoTx
 	}
-
-	if _, ok := d.tx.tables[table]; !ok {
-		return errNoTable
+	if d.tx.readOnly {
+		return errReadOnlyTx
 	}
 
-	// First see if we have unflushed data
-	pointer, ok := d.tx.unflushedDataPointer[table]
+	metadata, ok := d.tx.tables[table]
 	if !ok {
-		d.tx.unflushedDataPointer[table] = 0
-		pointer = 0 // not necessary, but I'll be explicit (maybe not idiomatic go?)
-		d.tx.unflushedData[table] = &[DATAOBJECT_SIZE][]any{}
+		return errNoTable
+	}
+	row, err := validateRow(metadata.Columns, row)
+	if err != nil {
+		return err
 	}
 
-	if pointer == DATAOBJECT_SIZE {
-		d.flushRows(table)
-		pointer = 0
+	// Flush what we have first if it's big enough for a dataobject.
+	unflushedRows := len(d.tx.unflushedData[table])
+	if (d.maxDataobjectRows > 0 && unflushedRows >= d.maxDataobjectRows) ||
+		(d.maxDataobjectBytes > 0 && unflushedRows > 0 && d.tx.unflushedBytes[table] >= d.maxDataobjectBytes) {
+		err := d.flushRows(ctx, table)
+		if err != nil {
+			return err
+		}
 	}
-	d.tx.unflushedData[table][pointer] = row
-	d.tx.unflushedDataPointer[table]++
+	d.tx.unflushedData[table] = append(d.tx.unflushedData[table], row)
+	d.tx.unflushedBytes[table] += rowSize(row)
 	return nil
 }
 
-type QueryRange struct {
-	// Inclusive
-	Start any
-	End   any
+// Roughly how many bytes the row's values take up, for WithMaxDataobjectBytes.
+func rowSize(row []any) int {
+	size := 0
+	for _, value := range row {
+		switch v := value.(type) {
+		case nil:
+			size++
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		case bool:
+			size++
+		case int64, float64:
+			size += 8
+		case time.Time:
+			size += 12
+		default:
+			// Untyped values, and decimals.
+			bytes, _ := json.Marshal(v)
+			size += len(bytes)
+		}
+	}
+	return size
 }
 
-func inRange(columnIndex int, queryRange QueryRange, row []any) (bool, error) {
-	value := row[columnIndex]
+// Deletes every row matching the predicate.
+func (d *DeltaLakeClient) DeleteRows(ctx context.Context, table string, predicate Predicate) error {
+	if d.tx == nil {
+		return errNoTx
+	}
+	if d.tx.readOnly {
+		return errReadOnlyTx
+	}
 
-	switch start := queryRange.Start.(type) {
-	case int:
-		end, ok1 := queryRange.End.(int)
-		if !ok1 {
-			return false, errTypeMismatch
-		}
-		val, err := utils.AsInt(value)
+	metadata, ok := d.tx.tables[table]
+	if !ok {
+		return errNoTable
+	}
+	bound, err := predicate.bind(metadata.Columns)
+	if err != nil {
+		return err
+	}
+	d.tx.readTables[table] = struct{}{}
+
+	// With a primary key, only the latest version of each row counts. If that matches, every version of the row has
+	// to go, otherwise an older version would become the latest.
+	matches, mightMatch := bound.matches, bound.mightMatch
+	if len(metadata.PrimaryKey) > 0 {
+		keys, err := d.latestKeysMatching(ctx, table, predicate)
 		if err != nil {
-			return false, err
+			return err
+		}
+		positions := primaryKeyPositions(metadata)
+		matches = func(row []any) (bool, error) {
+			_, ok := keys[primaryKeyOf(row, positions)]
+			return ok, nil
 		}
-		return start <= val && val <= end, nil
-	case string:
-		end, ok1 := queryRange.End.(string)
-		val, ok2 := value.(string)
-		if !ok1 || !ok2 {
-			return false, errTypeMismatch
+		if !bound.canSkipDataobjects(metadata) {
+			mightMatch = nil
 		}
-		return start <= val && val <= end, nil
-	default:
-		return false, errTypeMismatch
 	}
+
+	return d.rewriteRows(ctx, table, mightMatch, func(row []any) ([]any, bool, error) {
+		r, err := matches(row)
+		if err != nil || !r {
+			return row, false, err
+		}
+		return nil, true, nil
+	})
 }
 
-func (d *DeltaLakeClient) DeleteRows(table string, column string, queryRange QueryRange) error {
+// Sets the columns in `assignments` (column name -> new value) on every row matching the predicate. Primary key columns
+// can't be assigned to.
+func (d *DeltaLakeClient) UpdateRows(
+	ctx context.Context, table string, predicate Predicate, assignments map[string]any,
+) error {
 	if d.tx == nil {
-		return errExistingTx
+		return errNoTx
+	}
+	if d.tx.readOnly {
+		return errReadOnlyTx
 	}
 
-	columnIndex := slices.Index(d.tx.tables[table], column)
-	if columnIndex == -1 {
+	metadata, ok := d.tx.tables[table]
+	if !ok {
 		return errNoTable
 	}
+	bound, err := predicate.bind(metadata.Columns)
+	if err != nil {
+		return err
+	}
 
+	// Validate the new values once up front, rather than for every row.
+	positions := map[int]any{}
+	for name, value := range assignments {
+		i := columnIndex(metadata.Columns, name)
+		if i == -1 {
+			return fmt.Errorf("%w: %s in table %s", errNoColumn, name, table)
+		}
+		if slices.Contains(metadata.PrimaryKey, metadata.Columns[i].Id) {
+			return fmt.Errorf("%w: column %s", errPrimaryKeyUpdate, name)
+		}
+		positions[i], err = validateValue(metadata.Columns[i], value)
+		if err != nil {
+			return err
+		}
+	}
+	d.tx.readTables[table] = struct{}{}
+
+	// Unlike DeleteRows, we don't need to look at the latest version of each key for tables with a primary key. The key
+	// can't change, so updating an older version that matches is harmless as it will never be read.
+	return d.rewriteRows(ctx, table, bound.mightMatch, func(row []any) ([]any, bool, error) {
+		r, err := bound.matches(row)
+		if err != nil || !r {
+			return row, false, err
+		}
+
+		updated := slices.Clone(row)
+		for i, value := range positions {
+			updated[i] = value
+		}
+		return updated, true, nil
+	})
+}
+
+// Calls rewrite on every row of the table, and replaces any rows it changes with the row it returns (or removes them,
+// if it returns nil). Dataobjects that mightMatch says have no rows rewrite would change are skipped, if it's set.
+func (d *DeltaLakeClient) rewriteRows(
+	ctx context.Context,
+	table string,
+	mightMatch func(stats *dataobjectStats) bool,
+	rewrite func(row []any) ([]any, bool, error),
+) error {
 	// Unflushed data
-	for i := 0; i < d.tx.unflushedDataPointer[table]; i++ {
-		r, err := inRange(columnIndex, queryRange, d.tx.unflushedData[table][i])
+	changedAny := false
+	for i, row := range d.tx.unflushedData[table] {
+		row, changed, err := rewrite(row)
 		if err != nil {
 			return err
 		}
-		if r {
-			// Tombstone unflushed rows
-			d.tx.unflushedData[table][i] = nil
+		if changed {
+			// Unflushed rows can just be changed in place, or tombstoned if deleted.
+			d.tx.unflushedData[table][i] = row
+			changedAny = true
+		}
+	}
+	if changedAny {
+		// Then the tombstones dropped, so they don't count towards flushing (or get flushed as an empty dataobject).
+		d.tx.unflushedData[table] = slices.DeleteFunc(d.tx.unflushedData[table], func(row []any) bool { return row == nil })
+		d.tx.unflushedBytes[table] = 0
+		for _, row := range d.tx.unflushedData[table] {
+			d.tx.unflushedBytes[table] += rowSize(row)
 		}
 	}
 
 	// Flushed data
-	// We are doing copy-on-write, so we find any dataobjects that have matching
-	// rows, mark them as deleted and then rewrite those objects without said rows.
-	extantDataobjects := d.listExtantDataobjects(table)
+	// Dataobjects that only had rows deleted get a deletion vector marking those rows, rather than being rewritten. Once
+	// at least half the rows are deleted, or if any rows were changed, we do a copy-on-write instead: mark the dataobject
+	// as deleted and then rewrite it with the remaining/changed rows.
+	for _, object := range d.listExtantDataobjects(table) {
+		if d.pruned(object, mightMatch) {
+			continue
+		}
 
-	for _, dataobjectAction := range extantDataobjects {
-		var filteredRows [DATAOBJECT_SIZE][]any
-		filteredRowsPointer := 0
+		var rewrittenRows [][]any
+		updatedAny, deletedAny := false, false
 
-		dataobject, err := d.readDataobject(table, dataobjectAction.Name)
+		dataobject, err := d.readDataobject(ctx, table, object)
 		if err != nil {
 			return err
 		}
 
-		for i := 0; i < dataobject.Len; i++ {
-			row := dataobject.Data[i]
-
-			r, err := inRange(columnIndex, queryRange, row)
-			if err != nil {
-				return err
+		deleted := make([]bool, len(dataobject.Data))
+		deletedCount := 0
+		for i, row := range dataobject.Data {
+			// Nil if it was already deleted by the deletion vector.
+			changed := false
+			if row != nil {
+				row, changed, err = rewrite(row)
+				if err != nil {
+					return err
+				}
 			}
-			if !r {
-				filteredRows[filteredRowsPointer] = row
-				filteredRowsPointer++
+
+			if row == nil {
+				deleted[i] = true
+				deletedCount++
+				deletedAny = deletedAny || changed
+			} else {
+				rewrittenRows = append(rewrittenRows, row)
+				updatedAny = updatedAny || changed
 			}
 		}
 
-		// If this is true, we know we have filtered out some rows, so we need to delete the old dataobject and write a new
-		// one with the contents of our filtered rows array.
-		if filteredRowsPointer != dataobject.Len {
-			// We provide the TxId of the dataobject we are deleting, so when we are reading these later on, the re-written
-			// rows will be ordered chronologically in the same place as the original ones.
-			addDataobjectAction, err := d.writeDataObject(table, &filteredRows, dataobjectAction.TxId)
+		if !updatedAny && !deletedAny {
+			continue
+		}
+
+		if !updatedAny && deletedCount*2 < len(dataobject.Data) {
+			d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+				DeletionVector: &deletionVectorAction{
+					Name: dataobject.Name, Table: table, Deleted: encodeBitmap(deleted),
+				},
+			})
+			continue
+		}
+
+		// If every row was deleted there's nothing to rewrite, the dataobject just goes.
+		if len(rewrittenRows) > 0 {
+			// We provide the TxId and Seq of the dataobject we are deleting, so when we are reading these later on, the
+			// re-written rows will be ordered chronologically in the same place as the original ones.
+			addDataobjectAction, err := d.writeDataObject(ctx, table, rewrittenRows, object.TxId, object.Seq)
 			if err != nil {
 				return err
 			}
-
-			d.tx.Actions[table] = append(d.tx.Actions[table],
-				addDataobjectAction,
-				Action{
-					DeleteDataobject: &dataobjectActionT{
-						// However note the delete still has the current txId.
-						Name: dataobject.Name, Table: table, TxId: d.tx.Id,
-					},
-				},
-			)
+			d.tx.Actions[table] = append(d.tx.Actions[table], addDataobjectAction)
 		}
+
+		d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+			DeleteDataobject: &dataobjectActionT{
+				// However note the delete still has the current txId.
+				Name: dataobject.Name, Table: table, TxId: d.tx.Id, Path: object.Path,
+			},
+		})
 	}
 
 	return nil
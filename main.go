@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/rptynan/delta-lake/deltalakeclient"
@@ -19,8 +20,9 @@ func main() {
 	var objectstorage objectstorage.ObjectStorage = objectstorage.NewFileObjectStorage("./tmp")
 
 	client := deltalakeclient.NewClient(objectstorage)
+	ctx := context.Background()
 
-	err := client.NewTx()
+	err := client.NewTx(ctx)
 	if err != nil {
 		panic(err)
 	}
@@ -29,26 +31,26 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	err = client.WriteRow("users", []any{123, "bob", "bob@thebuilder.com"})
+	err = client.WriteRow(ctx, "users", []any{123, "bob", "bob@thebuilder.com"})
 	if err != nil {
 		panic(err)
 	}
-	scanIt, err := client.Scan("users")
+	scanIt, err := client.Scan(ctx, "users")
 	assertErr(err)
 	// fmt.Println(scanIt)
 	fmt.Println(scanIt.Next())
 	fmt.Println(scanIt.Next())
 
-	err = client.CommitTx()
+	err = client.CommitTx(ctx)
 	if err != nil {
 		panic(err)
 	}
 
 	// Read committed data in new tx
-	err = client.NewTx()
+	err = client.NewTx(ctx)
 	assertErr(err)
 
-	scanIt, err = client.Scan("users")
+	scanIt, err = client.Scan(ctx, "users")
 	assertErr(err)
 	// fmt.Println(scanIt)
 	fmt.Println(scanIt.Next())
@@ -0,0 +1,174 @@
+package objectstorage
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An in-process fake of the parts of the S3 API that s3ObjectStorage uses, so tests can run offline. Buckets are
+// created on first use.
+type FakeS3Server struct {
+	*httptest.Server
+
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+	// Page size for ListObjectsV2, small values are useful to exercise pagination.
+	MaxKeys int
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeS3Object
+	// See SetConditionalConflicts.
+	conditionalConflicts map[string]int
+}
+
+type fakeS3Object struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewFakeS3Server() *FakeS3Server {
+	f := &FakeS3Server{
+		AccessKeyID:     "fake-access-key",
+		SecretAccessKey: "fake-secret-key",
+		Region:          "us-east-1",
+		MaxKeys:         1000,
+		buckets:         map[string]map[string]fakeS3Object{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+// The next `n` conditional PUTs of key fail with 409 ConditionalRequestConflict, like S3 does when one races another
+// write to the same key.
+func (f *FakeS3Server) SetConditionalConflicts(key string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conditionalConflicts == nil {
+		f.conditionalConflicts = map[string]int{}
+	}
+	f.conditionalConflicts[key] = n
+}
+
+// Config for an s3ObjectStorage pointing at this server.
+func (f *FakeS3Server) Config(bucket string) S3Config {
+	return S3Config{
+		Endpoint:        f.URL,
+		Bucket:          bucket,
+		Region:          f.Region,
+		AccessKeyID:     f.AccessKeyID,
+		SecretAccessKey: f.SecretAccessKey,
+	}
+}
+
+func (f *FakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !f.authorized(r, body) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	objects, ok := f.buckets[bucket]
+	if !ok {
+		objects = map[string]fakeS3Object{}
+		f.buckets[bucket] = objects
+	}
+
+	switch {
+	case r.Method == http.MethodPut && key != "":
+		if f.conditionalConflicts[key] > 0 && r.Header.Get("If-None-Match") == "*" {
+			f.conditionalConflicts[key]--
+			http.Error(w, "ConditionalRequestConflict", http.StatusConflict)
+			return
+		}
+		if _, exists := objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+			http.Error(w, "PreconditionFailed", http.StatusPreconditionFailed)
+			return
+		}
+		objects[key] = fakeS3Object{body, time.Now()}
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && key != "":
+		object, exists := objects[key]
+		if !exists {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modTime.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case r.Method == http.MethodDelete && key != "":
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r, objects)
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *FakeS3Server) list(w http.ResponseWriter, r *http.Request, objects map[string]fakeS3Object) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	var keys []string
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// The continuation token is just an index into the sorted keys. Real S3 tokens are opaque, so clients can't rely
+	// on this.
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start > len(keys) {
+			http.Error(w, "InvalidArgument", http.StatusBadRequest)
+			return
+		}
+	}
+	end := min(start+f.MaxKeys, len(keys))
+
+	var result listBucketResult
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, struct{ Key string }{key})
+	}
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *FakeS3Server) authorized(r *http.Request, body []byte) bool {
+	date, err := time.Parse(s3TimeFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != sha256Hex(body) {
+		return false
+	}
+	signedHeaders, signature := s3Signature(r, payloadHash, f.Region, f.SecretAccessKey, date)
+	return r.Header.Get("Authorization") == "AWS4-HMAC-SHA256 Credential="+f.AccessKeyID+"/"+
+		date.Format("20060102")+"/"+f.Region+"/s3/aws4_request, SignedHeaders="+signedHeaders+", Signature="+signature
+}
@@ -0,0 +1,227 @@
+package objectstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned (possibly wrapped) for every fault injected by faultyObjectStorage.
+var ErrInjectedFault = errors.New("injected fault")
+
+// Torn writes are only injected for objects starting with this, see TornWriteRate.
+const tornWritePrefix = "_table_"
+
+// Returns how long an operation should take, using the provided RNG so results are reproducible.
+type LatencyDistribution func(random *rand.Rand) time.Duration
+
+func FixedLatency(d time.Duration) LatencyDistribution {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+func UniformLatency(min, max time.Duration) LatencyDistribution {
+	return func(random *rand.Rand) time.Duration {
+		return min + time.Duration(random.Int63n(int64(max-min)+1))
+	}
+}
+
+// Log-normal latencies have the long tail you see from S3: most requests are close to the median, but a few take many
+// times longer.
+func LogNormalLatency(median time.Duration, sigma float64) LatencyDistribution {
+	return func(random *rand.Rand) time.Duration {
+		return time.Duration(float64(median) * math.Exp(random.NormFloat64()*sigma))
+	}
+}
+
+// Faults to inject for a single kind of operation. Rates are probabilities between 0 and 1, checked in the order
+// below, so at most one fault is injected per call.
+type OperationFaults struct {
+	// Only inject faults for objects (or list prefixes) starting with this, empty means everything.
+	NamePrefix string
+	// Added to every call, including ones that fail.
+	Latency LatencyDistribution
+	// The call fails without doing anything.
+	ErrorRate float64
+	// PutIfAbsent of a dataobject (a name starting with _table_) only: only a prefix of the bytes ends up in the object,
+	// then the call fails. This breaks PutIfAbsent's promise to write all or nothing, which the client can live with for
+	// dataobjects, as one that failed to write is never committed, but not for log entries or checkpoints.
+	TornWriteRate float64
+	// PutIfAbsent only: the object is written, but the caller is told the call failed.
+	LostResponseRate float64
+}
+
+type FaultConfig struct {
+	// Seed for the RNG that drives every latency and fault decision.
+	Seed              int64
+	PutIfAbsent       OperationFaults
+	ListPrefixOrdered OperationFaults
+	Read              OperationFaults
+	Delete            OperationFaults
+	Stat              OperationFaults
+}
+
+// Counts of what has been injected so far, so tests can check faults actually happened.
+type FaultStats struct {
+	Errors        int
+	TornWrites    int
+	LostResponses int
+	TotalLatency  time.Duration
+	Calls         int
+}
+
+// Wraps another ObjectStorage and injects latency and failures into it, to see how the client copes with slow or
+// flaky storage.
+type faultyObjectStorage struct {
+	inner  ObjectStorage
+	config FaultConfig
+
+	// Protects everything below, rand.Rand isn't safe for concurrent use.
+	mu     sync.Mutex
+	random *rand.Rand
+	stats  FaultStats
+}
+
+func NewFaultyObjectStorage(inner ObjectStorage, config FaultConfig) *faultyObjectStorage {
+	return &faultyObjectStorage{
+		inner:  inner,
+		config: config,
+		random: rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+func (f *faultyObjectStorage) Stats() FaultStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+type fault int
+
+const (
+	noFault fault = iota
+	errorFault
+	tornWriteFault
+	lostResponseFault
+)
+
+// Decides (and sleeps for) the latency and which fault, if any, to inject for this call. Returns an error if ctx is
+// done before the latency is up.
+func (f *faultyObjectStorage) decide(ctx context.Context, faults OperationFaults, name string) (fault, float64, error) {
+	f.mu.Lock()
+	f.stats.Calls++
+	if !strings.HasPrefix(name, faults.NamePrefix) {
+		f.mu.Unlock()
+		return noFault, 0, nil
+	}
+
+	var latency time.Duration
+	if faults.Latency != nil {
+		latency = faults.Latency(f.random)
+		f.stats.TotalLatency += latency
+	}
+
+	tornWriteRate := faults.TornWriteRate
+	if !strings.HasPrefix(name, tornWritePrefix) {
+		tornWriteRate = 0
+	}
+	decision := noFault
+	roll := f.random.Float64()
+	switch {
+	case roll < faults.ErrorRate:
+		decision = errorFault
+		f.stats.Errors++
+	case roll < faults.ErrorRate+tornWriteRate:
+		decision = tornWriteFault
+		f.stats.TornWrites++
+	case roll < faults.ErrorRate+tornWriteRate+faults.LostResponseRate:
+		decision = lostResponseFault
+		f.stats.LostResponses++
+	}
+	// Only used for torn writes, but always drawn so the sequence of decisions doesn't depend on the outcome.
+	tornFraction := f.random.Float64()
+	f.mu.Unlock()
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return decision, tornFraction, nil
+	case <-ctx.Done():
+		return noFault, 0, ctx.Err()
+	}
+}
+
+func (f *faultyObjectStorage) PutIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	decision, tornFraction, err := f.decide(ctx, f.config.PutIfAbsent, name)
+	if err != nil {
+		return err
+	}
+	switch decision {
+	case errorFault:
+		return fmt.Errorf("%w: PutIfAbsent %s", ErrInjectedFault, name)
+	case tornWriteFault:
+		torn := bytes[:int(tornFraction*float64(len(bytes)))]
+		err := f.inner.PutIfAbsent(ctx, name, torn)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: torn PutIfAbsent %s (%d of %d bytes)", ErrInjectedFault, name, len(torn), len(bytes))
+	case lostResponseFault:
+		err := f.inner.PutIfAbsent(ctx, name, bytes)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: lost response for PutIfAbsent %s", ErrInjectedFault, name)
+	default:
+		return f.inner.PutIfAbsent(ctx, name, bytes)
+	}
+}
+
+func (f *faultyObjectStorage) ListPrefixOrdered(ctx context.Context, prefix string) ([]string, error) {
+	decision, _, err := f.decide(ctx, f.config.ListPrefixOrdered, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if decision == errorFault {
+		return nil, fmt.Errorf("%w: ListPrefixOrdered %s", ErrInjectedFault, prefix)
+	}
+	return f.inner.ListPrefixOrdered(ctx, prefix)
+}
+
+func (f *faultyObjectStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	decision, _, err := f.decide(ctx, f.config.Read, name)
+	if err != nil {
+		return nil, err
+	}
+	if decision == errorFault {
+		return nil, fmt.Errorf("%w: Read %s", ErrInjectedFault, name)
+	}
+	return f.inner.Read(ctx, name)
+}
+
+func (f *faultyObjectStorage) Delete(ctx context.Context, name string) error {
+	decision, _, err := f.decide(ctx, f.config.Delete, name)
+	if err != nil {
+		return err
+	}
+	if decision == errorFault {
+		return fmt.Errorf("%w: Delete %s", ErrInjectedFault, name)
+	}
+	return f.inner.Delete(ctx, name)
+}
+
+func (f *faultyObjectStorage) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	decision, _, err := f.decide(ctx, f.config.Stat, name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if decision == errorFault {
+		return ObjectInfo{}, fmt.Errorf("%w: Stat %s", ErrInjectedFault, name)
+	}
+	return f.inner.Stat(ctx, name)
+}
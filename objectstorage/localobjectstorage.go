@@ -1,7 +1,11 @@
 package objectstorage
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"sort"
@@ -19,7 +23,9 @@ func NewFileObjectStorage(basedir string) *fileObjectStorage {
 	return &fileObjectStorage{basedir}
 }
 
-func (fos *fileObjectStorage) PutIfAbsent(name string, bytes []byte) error {
+// Local file operations can't be interrupted, so we only check for cancellation between them (and between chunks of a
+// write).
+func (fos *fileObjectStorage) PutIfAbsent(ctx context.Context, name string, bytes []byte) error {
 	tmpfilename := path.Join(fos.basedir, uuid.New().String())
 	f, err := os.OpenFile(tmpfilename, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
@@ -31,7 +37,11 @@ func (fos *fileObjectStorage) PutIfAbsent(name string, bytes []byte) error {
 	for written < len(bytes) {
 		toWrite := min(written+bufSize, len(bytes))
 		n, err := f.Write(bytes[written:toWrite])
+		if err == nil {
+			err = ctx.Err()
+		}
 		if err != nil {
+			f.Close()
 			removeErr := os.Remove(tmpfilename)
 			utils.Assert(removeErr == nil, "could not remove")
 			return err
@@ -48,6 +58,10 @@ func (fos *fileObjectStorage) PutIfAbsent(name string, bytes []byte) error {
 	}
 
 	err = f.Close()
+	if err == nil {
+		// Last chance, once it's linked it's written.
+		err = ctx.Err()
+	}
 	if err != nil {
 		removeErr := os.Remove(tmpfilename)
 		utils.Assert(removeErr == nil, "could not remove")
@@ -59,13 +73,20 @@ func (fos *fileObjectStorage) PutIfAbsent(name string, bytes []byte) error {
 	if err != nil {
 		removeErr := os.Remove(tmpfilename)
 		utils.Assert(removeErr == nil, "could not remove")
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w: %s", ErrObjectExists, name)
+		}
 		return err
 	}
 
-	return nil
+	// The hard link keeps the data around, we don't need the temporary name any more.
+	return os.Remove(tmpfilename)
 }
 
-func (fos *fileObjectStorage) ListPrefixOrdered(prefix string) ([]string, error) {
+func (fos *fileObjectStorage) ListPrefixOrdered(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	dir := path.Join(fos.basedir)
 	f, err := os.Open(dir)
 	if err != nil {
@@ -93,7 +114,32 @@ func (fos *fileObjectStorage) ListPrefixOrdered(prefix string) ([]string, error)
 	return files, err
 }
 
-func (fos *fileObjectStorage) Read(name string) ([]byte, error) {
+func (fos *fileObjectStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	filename := path.Join(fos.basedir, name)
 	return os.ReadFile(filename)
 }
+
+func (fos *fileObjectStorage) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := os.Remove(path.Join(fos.basedir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (fos *fileObjectStorage) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path.Join(fos.basedir, name))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
@@ -0,0 +1,163 @@
+package objectstorage
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keeps everything in RAM, useful for tests, benchmarks, or embedding a lake that doesn't need to outlive the
+// process (or can be dumped to a tarball and loaded back).
+type memoryObjectStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	bytes   []byte
+	modTime time.Time
+}
+
+func NewMemoryObjectStorage() *memoryObjectStorage {
+	return &memoryObjectStorage{objects: map[string]memoryObject{}}
+}
+
+// Nothing here blocks, so cancellation is only checked on the way in.
+func (mos *memoryObjectStorage) PutIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return mos.put(name, bytes, time.Now())
+}
+
+func (mos *memoryObjectStorage) put(name string, bytes []byte, modTime time.Time) error {
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+
+	if _, exists := mos.objects[name]; exists {
+		return fmt.Errorf("%w: %s", ErrObjectExists, name)
+	}
+	// Copy, as the caller is free to reuse their slice.
+	mos.objects[name] = memoryObject{append([]byte{}, bytes...), modTime}
+	return nil
+}
+
+func (mos *memoryObjectStorage) ListPrefixOrdered(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	var files []string
+	for name := range mos.objects {
+		if strings.HasPrefix(name, prefix) {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (mos *memoryObjectStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	object, exists := mos.objects[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+	return append([]byte{}, object.bytes...), nil
+}
+
+func (mos *memoryObjectStorage) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+
+	delete(mos.objects, name)
+	return nil
+}
+
+func (mos *memoryObjectStorage) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ObjectInfo{}, err
+	}
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	object, exists := mos.objects[name]
+	if !exists {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+	return ObjectInfo{Name: name, Size: int64(len(object.bytes)), ModTime: object.modTime}, nil
+}
+
+// Writes every object as a file in a tar archive, in name order.
+func (mos *memoryObjectStorage) Dump(w io.Writer) error {
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	names := make([]string, 0, len(mos.objects))
+	for name := range mos.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		object := mos.objects[name]
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(object.bytes)),
+			ModTime: object.modTime,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(object.bytes)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Reads a tar archive written by Dump (or any tar of regular files) into a new in-memory storage.
+func LoadMemoryObjectStorage(r io.Reader) (*memoryObjectStorage, error) {
+	mos := NewMemoryObjectStorage()
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return mos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		bytes, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		err = mos.put(header.Name, bytes, header.ModTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
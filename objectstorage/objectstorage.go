@@ -1,8 +1,34 @@
 package objectstorage
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned (possibly wrapped) by PutIfAbsent when the object already exists.
+var ErrObjectExists = errors.New("object already exists")
+
+// Returned (possibly wrapped) by PutIfAbsent when it raced another write to the same object. Nothing was written, but
+// the other write may or may not have succeeded, so it's worth checking what's there and trying again.
+var ErrWriteConflict = errors.New("conflicting write")
+
+// Every method gives up with an error wrapping ctx.Err() once ctx is done, though it may not notice straight away.
 type ObjectStorage interface {
-	PutIfAbsent(name string, bytes []byte) error
+	// Either writes the whole object or none of it, even if cancelled. If it's cancelled while the write is in flight,
+	// the caller can't know which. (Except with faultyObjectStorage's torn writes, see TornWriteRate.)
+	PutIfAbsent(ctx context.Context, name string, bytes []byte) error
 	// Must return the list of files in ascending order
-	ListPrefixOrdered(prefix string) ([]string, error)
-	Read(name string) ([]byte, error)
+	ListPrefixOrdered(ctx context.Context, prefix string) ([]string, error)
+	Read(ctx context.Context, name string) ([]byte, error)
+	// Deleting an object that doesn't exist is not an error.
+	Delete(ctx context.Context, name string) error
+	// Returns an error wrapping fs.ErrNotExist if the object doesn't exist.
+	Stat(ctx context.Context, name string) (ObjectInfo, error)
+}
+
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
 }
@@ -0,0 +1,330 @@
+package objectstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	// Base URL of the S3-compatible service, e.g. "http://localhost:9000" for minio. Requests are made path-style,
+	// i.e. <Endpoint>/<Bucket>/<key>.
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Optional, defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+type s3ObjectStorage struct {
+	config S3Config
+	client *http.Client
+}
+
+func NewS3ObjectStorage(config S3Config) *s3ObjectStorage {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &s3ObjectStorage{config, client}
+}
+
+// S3 supports conditional writes with `If-None-Match: *`, which gives us the atomic create we need for the transaction
+// log. A 412 means someone else got there first. A 409 (ConditionalRequestConflict) means another write to the same
+// object was in flight at the same time, and ours was rejected without knowing whether theirs will succeed.
+func (s3 *s3ObjectStorage) PutIfAbsent(ctx context.Context, name string, data []byte) error {
+	req, err := s3.newRequest(ctx, http.MethodPut, name, nil, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("If-None-Match", "*")
+
+	resp, err := s3.do(req, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %s", ErrObjectExists, name)
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %s", ErrWriteConflict, name)
+	default:
+		return s3Error(resp, name)
+	}
+}
+
+type listBucketResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// ListObjectsV2 already returns keys in ascending UTF-8 binary order, so we just need to follow the pages.
+func (s3 *s3ObjectStorage) ListPrefixOrdered(ctx context.Context, prefix string) ([]string, error) {
+	var files []string
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		req, err := s3.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s3.do(req, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error(resp, prefix)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			files = append(files, object.Key)
+		}
+
+		if !result.IsTruncated {
+			return files, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (s3 *s3ObjectStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	req, err := s3.newRequest(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s3.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp, name)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// S3 deletes are idempotent, deleting a missing key still succeeds.
+func (s3 *s3ObjectStorage) Delete(ctx context.Context, name string) error {
+	req, err := s3.newRequest(ctx, http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s3.do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp, name)
+	}
+	return nil
+}
+
+func (s3 *s3ObjectStorage) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	req, err := s3.newRequest(ctx, http.MethodHead, name, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp, err := s3.do(req, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ObjectInfo{}, s3Error(resp, name)
+	}
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Requests are cancelled along with ctx, including reading their response bodies.
+func (s3 *s3ObjectStorage) newRequest(
+	ctx context.Context, method, key string, query url.Values, body []byte,
+) (*http.Request, error) {
+	u := fmt.Sprintf("%s/%s", s3.config.Endpoint, s3.config.Bucket)
+	if key != "" {
+		u += "/" + s3URIEncode(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + s3CanonicalQuery(query)
+	}
+	return http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+}
+
+func (s3 *s3ObjectStorage) do(req *http.Request, body []byte) (*http.Response, error) {
+	SignV4(req, body, s3.config.Region, s3.config.AccessKeyID, s3.config.SecretAccessKey, time.Now())
+	return s3.client.Do(req)
+}
+
+func s3Error(resp *http.Response, name string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 request for %s failed with %s: %s", name, resp.Status, body)
+}
+
+// AWS Signature Version 4, see https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+
+const s3TimeFormat = "20060102T150405Z"
+
+// Signs a request to S3 with the given credentials as of now, setting its X-Amz-Date, X-Amz-Content-Sha256 and
+// Authorization headers. Body must be the whole body of the request. Exported so tests can check it against AWS's own
+// examples, since the fake server checks requests with the same code.
+func SignV4(req *http.Request, body []byte, region, accessKeyID, secretAccessKey string, now time.Time) {
+	now = now.UTC()
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders, signature := s3Signature(req, payloadHash, region, secretAccessKey, now)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), region)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// Returns the signed headers and signature for a request that already has its X-Amz-* headers set. Shared with the
+// fake server so it can check what it's sent.
+func s3Signature(req *http.Request, payloadHash, region, secretAccessKey string, now time.Time) (string, string) {
+	// We always sign host and any x-amz-* / if-* headers we set.
+	headers := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "if-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, true),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format(s3TimeFormat),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3URIEncode(k, false)+"="+s3URIEncode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// S3's flavour of URI encoding: everything except unreserved characters is percent-encoded, optionally leaving '/'
+// alone for paths.
+func s3URIEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
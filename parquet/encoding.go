@@ -0,0 +1,260 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingRLEDictionary   = 8
+)
+
+// Number of bits needed for levels up to max.
+func bitWidth(max int) int {
+	return bits.Len(uint(max))
+}
+
+// Decodes n values of the RLE/bit-packing hybrid encoding, used for definition levels, dictionary indexes and
+// booleans. It's a sequence of runs, each starting with a uvarint header whose lowest bit says whether it's a run of
+// one repeated value, or of values bit-packed in groups of 8.
+func decodeHybrid(b []byte, width int, n int) ([]int, error) {
+	if width > 32 {
+		return nil, errCorrupt
+	}
+	values := make([]int, 0, n)
+	for len(values) < n {
+		header, read := binary.Uvarint(b)
+		if read <= 0 {
+			return nil, errCorrupt
+		}
+		b = b[read:]
+
+		if header&1 == 0 {
+			count := header >> 1
+			valueBytes := (width + 7) / 8
+			if len(b) < valueBytes {
+				return nil, errCorrupt
+			}
+			value := 0
+			for i := valueBytes - 1; i >= 0; i-- {
+				value = value<<8 | int(b[i])
+			}
+			b = b[valueBytes:]
+			for i := uint64(0); i < count && len(values) < n; i++ {
+				values = append(values, value)
+			}
+		} else {
+			groups := header >> 1
+			if groups*uint64(width) > uint64(len(b)) {
+				return nil, errCorrupt
+			}
+			packed := b[:groups*uint64(width)]
+			b = b[groups*uint64(width):]
+			// Values are packed least significant bit first.
+			for i := 0; i < int(groups)*8 && len(values) < n; i++ {
+				value := 0
+				for j := 0; j < width; j++ {
+					bit := i*width + j
+					value |= int(packed[bit/8]>>(bit%8)&1) << j
+				}
+				values = append(values, value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// We only write runs of repeated values, which is fine for levels (usually long runs) and booleans (short ones, but
+// we don't write many).
+func appendHybrid(b []byte, width int, values []int) []byte {
+	valueBytes := (width + 7) / 8
+	for i := 0; i < len(values); {
+		run := 1
+		for i+run < len(values) && values[i+run] == values[i] {
+			run++
+		}
+		b = binary.AppendUvarint(b, uint64(run)<<1)
+		for j := range valueBytes {
+			b = append(b, byte(values[i]>>(8*j)))
+		}
+		i += run
+	}
+	return b
+}
+
+// Decodes n values of type t written with the PLAIN encoding, which is just the values one after another (byte
+// arrays with a 4 byte length first). Booleans are bit-packed.
+func decodePlain(b []byte, t Type, typeLength int, n int) ([]any, error) {
+	values := make([]any, n)
+	fixed := func(size int) ([]byte, error) {
+		if len(b) < size {
+			return nil, errCorrupt
+		}
+		v := b[:size]
+		b = b[size:]
+		return v, nil
+	}
+
+	for i := range values {
+		switch t {
+		case Boolean:
+			if len(b) <= i/8 {
+				return nil, errCorrupt
+			}
+			values[i] = b[i/8]>>(i%8)&1 == 1
+		case Int32:
+			v, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = int32(binary.LittleEndian.Uint32(v))
+		case Int64:
+			v, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = int64(binary.LittleEndian.Uint64(v))
+		case Int96:
+			v, err := fixed(12)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = [12]byte(v)
+		case Float:
+			v, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(v))
+		case Double:
+			v, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(v))
+		case ByteArray:
+			length, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			v, err := fixed(int(binary.LittleEndian.Uint32(length)))
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		case FixedLenByteArray:
+			v, err := fixed(typeLength)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		default:
+			return nil, fmt.Errorf("%w: type %d", ErrUnsupported, t)
+		}
+	}
+	return values, nil
+}
+
+func appendPlain(b []byte, t Type, typeLength int, values []any) ([]byte, error) {
+	if t == Boolean {
+		packed := make([]byte, (len(values)+7)/8)
+		for i, value := range values {
+			v, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%w: %T for type boolean", ErrWrongType, value)
+			}
+			if v {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append(b, packed...), nil
+	}
+
+	for _, value := range values {
+		ok := true
+		switch t {
+		case Int32:
+			var v int32
+			v, ok = value.(int32)
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		case Int64:
+			var v int64
+			v, ok = value.(int64)
+			b = binary.LittleEndian.AppendUint64(b, uint64(v))
+		case Int96:
+			var v [12]byte
+			v, ok = value.([12]byte)
+			b = append(b, v[:]...)
+		case Float:
+			var v float32
+			v, ok = value.(float32)
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+		case Double:
+			var v float64
+			v, ok = value.(float64)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		case ByteArray:
+			var v []byte
+			v, ok = value.([]byte)
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		case FixedLenByteArray:
+			var v []byte
+			v, ok = value.([]byte)
+			ok = ok && len(v) == typeLength
+			b = append(b, v...)
+		default:
+			return nil, fmt.Errorf("%w: type %d", ErrUnsupported, t)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %T for type %d", ErrWrongType, value, t)
+		}
+	}
+	return b, nil
+}
+
+// Decodes the n non-null values of a data page.
+func decodeValues(b []byte, encoding int64, t Type, typeLength int, n int, dictionary []any) ([]any, error) {
+	switch encoding {
+	case encodingPlain:
+		return decodePlain(b, t, typeLength, n)
+	case encodingPlainDictionary, encodingRLEDictionary:
+		// One byte with the width of the indexes, then the indexes.
+		if len(b) < 1 {
+			return nil, errCorrupt
+		}
+		indexes, err := decodeHybrid(b[1:], int(b[0]), n)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]any, n)
+		for i, index := range indexes {
+			if index >= len(dictionary) {
+				return nil, errCorrupt
+			}
+			values[i] = dictionary[index]
+		}
+		return values, nil
+	case encodingRLE:
+		// Only valid for booleans, prefixed with the length like levels are in v1 data pages.
+		if t != Boolean || len(b) < 4 {
+			return nil, errCorrupt
+		}
+		bools, err := decodeHybrid(b[4:], 1, n)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]any, n)
+		for i, v := range bools {
+			values[i] = v == 1
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: encoding %d", ErrUnsupported, encoding)
+	}
+}
@@ -0,0 +1,619 @@
+// A small Parquet reader and writer, for flat schemas (no nested or repeated columns).
+//
+// Files are written with one row group, one PLAIN encoded data page per column, and gzip compression. Reading also
+// handles dictionary encoding, v2 data pages, multiple row groups and snappy compression, which covers what most
+// writers produce by default.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrCorrupt     = errors.New("parquet: corrupt file")
+	ErrUnsupported = errors.New("parquet: unsupported feature")
+	ErrWrongType   = errors.New("parquet: value doesn't match column type")
+)
+
+// Unexported alias, so the internals read a bit shorter.
+var errCorrupt = ErrCorrupt
+
+const magic = "PAR1"
+
+// Larger pages than this are assumed to be corrupt, rather than allocating for them.
+const maxPageSize = 1 << 30
+
+// Physical types, i.e. how values are stored.
+type Type int32
+
+const (
+	Boolean           Type = 0
+	Int32             Type = 1
+	Int64             Type = 2
+	Int96             Type = 3
+	Float             Type = 4
+	Double            Type = 5
+	ByteArray         Type = 6
+	FixedLenByteArray Type = 7
+)
+
+// Logical types, i.e. how the stored values should be interpreted. Only the ones we have some use for are here, values
+// of columns with other logical types are read as their physical type.
+type LogicalType int
+
+const (
+	None LogicalType = iota
+	// ByteArray, UTF-8.
+	String
+	// ByteArray, UTF-8 encoded JSON.
+	JSON
+	// Int32, Int64, ByteArray or FixedLenByteArray, an integer (big-endian two's complement for the byte arrays) to be
+	// divided by 10^Scale.
+	Decimal
+	// Int32, days since the Unix epoch.
+	Date
+	// Int64, since the Unix epoch.
+	TimestampMillis
+	TimestampMicros
+	TimestampNanos
+)
+
+type Field struct {
+	Name     string
+	Type     Type
+	Logical  LogicalType
+	Optional bool
+	// Only for FixedLenByteArray.
+	TypeLength int
+	// Only for Decimal.
+	Scale, Precision int
+	// Parquet's field_id, for tracking columns through renames. 0 means not set.
+	Id int
+}
+
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedEnum            = 4
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+	convertedJSON            = 19
+
+	logicalString    = 1
+	logicalEnum      = 4
+	logicalDecimal   = 5
+	logicalDate      = 6
+	logicalTimestamp = 8
+	logicalJSON      = 12
+
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+
+	compressionUncompressed = 0
+	compressionSnappy       = 1
+	compressionGzip         = 2
+)
+
+// A parsed Parquet file. Only the metadata is read up front, see Rows.
+type File struct {
+	Fields  []Field
+	NumRows int64
+
+	data      []byte
+	rowGroups []thriftStruct
+}
+
+func Open(data []byte) (*File, error) {
+	if len(data) < 2*len(magic)+4 || !bytes.HasPrefix(data, []byte(magic)) || !bytes.HasSuffix(data, []byte(magic)) {
+		return nil, errCorrupt
+	}
+	footerLength := uint64(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLength > uint64(len(data)-12) {
+		return nil, errCorrupt
+	}
+	r := &thriftReader{b: data[uint64(len(data)-8)-footerLength : len(data)-8]}
+	metadata := r.readStruct()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	f := &File{data: data}
+	f.NumRows, _ = metadata.int(3)
+	if f.NumRows < 0 {
+		return nil, errCorrupt
+	}
+	schema := metadata.list(2)
+	if len(schema) == 0 {
+		return nil, errCorrupt
+	}
+	// The first element is the root, which all the columns are children of.
+	for _, element := range schema[1:] {
+		field, err := parseField(element)
+		if err != nil {
+			return nil, err
+		}
+		f.Fields = append(f.Fields, field)
+	}
+	if children, _ := schema[0].(thriftStruct).int(5); children != int64(len(f.Fields)) {
+		return nil, fmt.Errorf("%w: nested columns", ErrUnsupported)
+	}
+
+	for _, rowGroup := range metadata.list(4) {
+		rg, ok := rowGroup.(thriftStruct)
+		if !ok || len(rg.list(1)) != len(f.Fields) {
+			return nil, errCorrupt
+		}
+		f.rowGroups = append(f.rowGroups, rg)
+	}
+	return f, nil
+}
+
+func parseField(element any) (Field, error) {
+	e, ok := element.(thriftStruct)
+	if !ok {
+		return Field{}, errCorrupt
+	}
+	if children, _ := e.int(5); children > 0 {
+		return Field{}, fmt.Errorf("%w: nested columns", ErrUnsupported)
+	}
+
+	field := Field{Name: string(e.binary(4))}
+	t, ok := e.int(1)
+	if !ok || t < int64(Boolean) || t > int64(FixedLenByteArray) {
+		return Field{}, errCorrupt
+	}
+	field.Type = Type(t)
+	repetition, _ := e.int(3)
+	switch repetition {
+	case repetitionRequired:
+	case repetitionOptional:
+		field.Optional = true
+	default:
+		return Field{}, fmt.Errorf("%w: repeated columns", ErrUnsupported)
+	}
+	typeLength, _ := e.int(2)
+	scale, _ := e.int(7)
+	precision, _ := e.int(8)
+	id, _ := e.int(9)
+	field.TypeLength, field.Scale, field.Precision, field.Id = int(typeLength), int(scale), int(precision), int(id)
+	// Some writers set the type length for every column, but it only means anything for fixed length ones.
+	if field.Type != FixedLenByteArray {
+		field.TypeLength = 0
+	} else if field.TypeLength <= 0 {
+		return Field{}, errCorrupt
+	}
+
+	// Newer writers set the logical type, older ones the converted type (and most set both).
+	if logical := e.structField(10); logical != nil {
+		switch {
+		case logical[logicalString] != nil, logical[logicalEnum] != nil:
+			field.Logical = String
+		case logical[logicalJSON] != nil:
+			field.Logical = JSON
+		case logical[logicalDecimal] != nil:
+			field.Logical = Decimal
+			decimal := logical.structField(logicalDecimal)
+			scale, _ := decimal.int(1)
+			precision, _ := decimal.int(2)
+			field.Scale, field.Precision = int(scale), int(precision)
+		case logical[logicalDate] != nil:
+			field.Logical = Date
+		case logical[logicalTimestamp] != nil:
+			unit := logical.structField(logicalTimestamp).structField(2)
+			switch {
+			case unit[1] != nil:
+				field.Logical = TimestampMillis
+			case unit[2] != nil:
+				field.Logical = TimestampMicros
+			case unit[3] != nil:
+				field.Logical = TimestampNanos
+			}
+		}
+	} else if converted, ok := e.int(6); ok {
+		switch converted {
+		case convertedUTF8, convertedEnum:
+			field.Logical = String
+		case convertedJSON:
+			field.Logical = JSON
+		case convertedDecimal:
+			field.Logical = Decimal
+		case convertedDate:
+			field.Logical = Date
+		case convertedTimestampMillis:
+			field.Logical = TimestampMillis
+		case convertedTimestampMicros:
+			field.Logical = TimestampMicros
+		}
+	}
+	return field, nil
+}
+
+// Reads all the rows. Values are nil for nulls, otherwise the Go type for the field's physical type: bool, int32,
+// int64, [12]byte (Int96), float32, float64 or []byte (both byte array types). Logical types aren't applied.
+func (f *File) Rows() ([][]any, error) {
+	rows := make([][]any, 0, min(f.NumRows, int64(len(f.data))))
+	for _, rowGroup := range f.rowGroups {
+		numRows, _ := rowGroup.int(3)
+		if numRows < 0 || numRows > f.NumRows-int64(len(rows)) {
+			return nil, errCorrupt
+		}
+		start := len(rows)
+		for range numRows {
+			rows = append(rows, make([]any, len(f.Fields)))
+		}
+
+		for i, chunk := range rowGroup.list(1) {
+			c, ok := chunk.(thriftStruct)
+			if !ok {
+				return nil, errCorrupt
+			}
+			values, err := f.readColumnChunk(f.Fields[i], c.structField(3), int(numRows))
+			if err != nil {
+				return nil, err
+			}
+			for j, value := range values {
+				rows[start+j][i] = value
+			}
+		}
+	}
+	if int64(len(rows)) != f.NumRows {
+		return nil, errCorrupt
+	}
+	return rows, nil
+}
+
+func (f *File) readColumnChunk(field Field, metadata thriftStruct, numRows int) ([]any, error) {
+	compression, _ := metadata.int(4)
+	start, _ := metadata.int(9)
+	// Some writers set the dictionary page offset to 0 when there isn't one.
+	if dictionaryOffset, ok := metadata.int(11); ok && dictionaryOffset > 0 && dictionaryOffset < start {
+		start = dictionaryOffset
+	}
+	size, _ := metadata.int(7)
+	if start < 0 || size < 0 || start+size > int64(len(f.data)) {
+		return nil, errCorrupt
+	}
+	r := &thriftReader{b: f.data[start : start+size]}
+
+	var dictionary []any
+	values := make([]any, 0, numRows)
+	for len(values) < numRows {
+		header := r.readStruct()
+		if r.err != nil {
+			return nil, r.err
+		}
+		pageType, _ := header.int(1)
+		uncompressedSize, _ := header.int(2)
+		compressedSize, _ := header.int(3)
+		if uncompressedSize < 0 || uncompressedSize > maxPageSize || compressedSize < 0 {
+			return nil, errCorrupt
+		}
+		page := r.bytes(uint64(compressedSize))
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		switch pageType {
+		case pageDictionary:
+			page, err := decompress(compression, page, uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+			numValues, _ := header.structField(7).int(1)
+			if numValues < 0 || numValues > uncompressedSize*8 {
+				return nil, errCorrupt
+			}
+			dictionary, err = decodePlain(page, field.Type, field.TypeLength, int(numValues))
+			if err != nil {
+				return nil, err
+			}
+
+		case pageData:
+			page, err := decompress(compression, page, uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+			dataPage := header.structField(5)
+			numValues, _ := dataPage.int(1)
+			encoding, _ := dataPage.int(2)
+			if numValues < 0 || numValues > int64(numRows-len(values)) {
+				return nil, errCorrupt
+			}
+
+			var levels []int
+			if field.Optional {
+				// v1 pages have the definition levels first, prefixed by their length.
+				if len(page) < 4 {
+					return nil, errCorrupt
+				}
+				levelsLength := uint64(binary.LittleEndian.Uint32(page))
+				if levelsLength > uint64(len(page)-4) {
+					return nil, errCorrupt
+				}
+				levels, err = decodeHybrid(page[4:4+levelsLength], 1, int(numValues))
+				if err != nil {
+					return nil, err
+				}
+				page = page[4+levelsLength:]
+			}
+			values, err = appendPageValues(values, field, page, encoding, int(numValues), levels, dictionary)
+			if err != nil {
+				return nil, err
+			}
+
+		case pageDataV2:
+			dataPage := header.structField(8)
+			numValues, _ := dataPage.int(1)
+			encoding, _ := dataPage.int(4)
+			levelsLength, _ := dataPage.int(5)
+			repetitionLength, _ := dataPage.int(6)
+			if numValues < 0 || numValues > int64(numRows-len(values)) || levelsLength < 0 || repetitionLength != 0 ||
+				levelsLength > int64(len(page)) {
+				return nil, errCorrupt
+			}
+
+			// v2 pages have the levels uncompressed and without the length prefix, only the values are compressed.
+			var levels []int
+			var err error
+			if field.Optional {
+				levels, err = decodeHybrid(page[:levelsLength], 1, int(numValues))
+				if err != nil {
+					return nil, err
+				}
+			}
+			page = page[levelsLength:]
+			if compressed, ok := dataPage.bool(7); compressed || !ok {
+				page, err = decompress(compression, page, uncompressedSize-levelsLength)
+				if err != nil {
+					return nil, err
+				}
+			}
+			values, err = appendPageValues(values, field, page, encoding, int(numValues), levels, dictionary)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			// Index pages, which we don't need.
+		}
+	}
+	return values, nil
+}
+
+// Decodes the values in a data page and appends them (and nils for any nulls) to values.
+func appendPageValues(
+	values []any, field Field, page []byte, encoding int64, numValues int, levels []int, dictionary []any,
+) ([]any, error) {
+	nonNull := numValues
+	for _, level := range levels {
+		if level == 0 {
+			nonNull--
+		}
+	}
+
+	decoded, err := decodeValues(page, encoding, field.Type, field.TypeLength, nonNull, dictionary)
+	if err != nil {
+		return nil, err
+	}
+	for i := range numValues {
+		if levels != nil && levels[i] == 0 {
+			values = append(values, nil)
+		} else {
+			values = append(values, decoded[0])
+			decoded = decoded[1:]
+		}
+	}
+	return values, nil
+}
+
+func decompress(compression int64, page []byte, uncompressedSize int64) ([]byte, error) {
+	switch compression {
+	case compressionUncompressed:
+		return page, nil
+	case compressionSnappy:
+		return decodeSnappy(page)
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+		decompressed, err := io.ReadAll(io.LimitReader(r, uncompressedSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+		return decompressed, nil
+	default:
+		return nil, fmt.Errorf("%w: compression %d", ErrUnsupported, compression)
+	}
+}
+
+// Writes rows (with values in the Go types Rows returns, see there) as a Parquet file.
+func Write(fields []Field, rows [][]any) ([]byte, error) {
+	data := []byte(magic)
+
+	// Column chunk metadata, to go in the footer.
+	chunks := &thriftWriter{}
+	for i, field := range fields {
+		var levels []int
+		var values []any
+		for _, row := range rows {
+			if len(row) != len(fields) {
+				return nil, fmt.Errorf("%w: row has %d values, expected %d", ErrWrongType, len(row), len(fields))
+			}
+			switch {
+			case row[i] != nil:
+				levels = append(levels, 1)
+				values = append(values, row[i])
+			case field.Optional:
+				levels = append(levels, 0)
+			default:
+				return nil, fmt.Errorf("%w: null in required column %s", ErrWrongType, field.Name)
+			}
+		}
+
+		var page []byte
+		if field.Optional {
+			encodedLevels := appendHybrid(nil, 1, levels)
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(encodedLevels)))
+			page = append(page, encodedLevels...)
+		}
+		page, err := appendPlain(page, field.Type, field.TypeLength, values)
+		if err != nil {
+			return nil, err
+		}
+
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		_, err = w.Write(page)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		header := &thriftWriter{}
+		header.structBegin()
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(compressed.Len()))
+		header.structField(5)
+		header.structBegin()
+		header.i32(1, int32(len(rows)))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.structEnd()
+		header.structEnd()
+
+		offset := int64(len(data))
+		data = append(data, header.b...)
+		data = append(data, compressed.Bytes()...)
+
+		chunks.structBegin()
+		chunks.i64(2, offset)
+		chunks.structField(3)
+		chunks.structBegin()
+		chunks.i32(1, int32(field.Type))
+		chunks.listField(2, thriftI32, 2)
+		chunks.i32Element(encodingPlain)
+		chunks.i32Element(encodingRLE)
+		chunks.listField(3, thriftBinary, 1)
+		chunks.binaryElement([]byte(field.Name))
+		chunks.i32(4, compressionGzip)
+		chunks.i64(5, int64(len(rows)))
+		chunks.i64(6, int64(len(header.b)+len(page)))
+		chunks.i64(7, int64(len(header.b)+compressed.Len()))
+		chunks.i64(9, offset)
+		chunks.structEnd()
+		chunks.structEnd()
+	}
+
+	footer := &thriftWriter{}
+	footer.structBegin()
+	footer.i32(1, 1)
+	footer.listField(2, thriftStructType, len(fields)+1)
+	footer.structBegin()
+	footer.binary(4, []byte("schema"))
+	footer.i32(5, int32(len(fields)))
+	footer.structEnd()
+	for _, field := range fields {
+		writeField(footer, field)
+	}
+	footer.i64(3, int64(len(rows)))
+	footer.listField(4, thriftStructType, 1)
+	footer.structBegin()
+	footer.listField(1, thriftStructType, len(fields))
+	footer.b = append(footer.b, chunks.b...)
+	footer.i64(2, int64(len(data)-len(magic)))
+	footer.i64(3, int64(len(rows)))
+	footer.structEnd()
+	footer.binary(6, []byte("github.com/rptynan/delta-lake"))
+	footer.structEnd()
+
+	data = append(data, footer.b...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(footer.b)))
+	return append(data, magic...), nil
+}
+
+func writeField(w *thriftWriter, field Field) {
+	w.structBegin()
+	w.i32(1, int32(field.Type))
+	if field.Type == FixedLenByteArray {
+		w.i32(2, int32(field.TypeLength))
+	}
+	if field.Optional {
+		w.i32(3, repetitionOptional)
+	} else {
+		w.i32(3, repetitionRequired)
+	}
+	w.binary(4, []byte(field.Name))
+
+	// Both the converted and logical types, for older readers.
+	switch field.Logical {
+	case String:
+		w.i32(6, convertedUTF8)
+	case JSON:
+		w.i32(6, convertedJSON)
+	case Decimal:
+		w.i32(6, convertedDecimal)
+		w.i32(7, int32(field.Scale))
+		w.i32(8, int32(field.Precision))
+	case Date:
+		w.i32(6, convertedDate)
+	case TimestampMillis:
+		w.i32(6, convertedTimestampMillis)
+	case TimestampMicros:
+		w.i32(6, convertedTimestampMicros)
+	}
+	if field.Id != 0 {
+		w.i32(9, int32(field.Id))
+	}
+	if field.Logical != None {
+		w.structField(10)
+		w.structBegin()
+		switch field.Logical {
+		case String:
+			w.structField(logicalString)
+			w.structBegin()
+			w.structEnd()
+		case JSON:
+			w.structField(logicalJSON)
+			w.structBegin()
+			w.structEnd()
+		case Decimal:
+			w.structField(logicalDecimal)
+			w.structBegin()
+			w.i32(1, int32(field.Scale))
+			w.i32(2, int32(field.Precision))
+			w.structEnd()
+		case Date:
+			w.structField(logicalDate)
+			w.structBegin()
+			w.structEnd()
+		case TimestampMillis, TimestampMicros, TimestampNanos:
+			w.structField(logicalTimestamp)
+			w.structBegin()
+			w.bool(1, true)
+			w.structField(2)
+			w.structBegin()
+			w.structField(int16(field.Logical-TimestampMillis) + 1)
+			w.structBegin()
+			w.structEnd()
+			w.structEnd()
+			w.structEnd()
+		}
+		w.structEnd()
+	}
+	w.structEnd()
+}
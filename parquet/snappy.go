@@ -0,0 +1,80 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// Decodes a snappy block (not the framed format), which is what Parquet uses. Most writers default to snappy, so we
+// need to be able to read it, but we don't write it.
+func decodeSnappy(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > maxPageSize {
+		return nil, errCorrupt
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var literal, copyLength, offset int
+		switch tag & 0x03 {
+		case 0x00:
+			literal = int(tag >> 2)
+			src = src[1:]
+			// Longer lengths are in the next 1-4 bytes.
+			if literal >= 60 {
+				extra := literal - 59
+				if len(src) < extra {
+					return nil, errCorrupt
+				}
+				literal = 0
+				for i := extra - 1; i >= 0; i-- {
+					literal = literal<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			literal++
+			if literal > len(src) {
+				return nil, errCorrupt
+			}
+			dst = append(dst, src[:literal]...)
+			src = src[literal:]
+			continue
+		case 0x01:
+			if len(src) < 2 {
+				return nil, errCorrupt
+			}
+			copyLength = 4 + int(tag>>2)&0x07
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+		case 0x02:
+			if len(src) < 3 {
+				return nil, errCorrupt
+			}
+			copyLength = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 0x03:
+			if len(src) < 5 {
+				return nil, errCorrupt
+			}
+			copyLength = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+copyLength) > length {
+			return nil, errCorrupt
+		}
+		// Copies can overlap what they're writing (e.g. a run of one byte), so go byte by byte.
+		start := len(dst) - offset
+		for i := range copyLength {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,262 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Parquet's metadata is serialised with Thrift's compact protocol. Rather than generate code for all of Parquet's
+// Thrift definitions, structs are read into a generic form (field id -> value) and the few fields we need are picked
+// out of that, and written field by field.
+
+const (
+	thriftStop       = 0
+	thriftTrue       = 1
+	thriftFalse      = 2
+	thriftByte       = 3
+	thriftI16        = 4
+	thriftI32        = 5
+	thriftI64        = 6
+	thriftDouble     = 7
+	thriftBinary     = 8
+	thriftList       = 9
+	thriftSet        = 10
+	thriftMap        = 11
+	thriftStructType = 12
+)
+
+// A decoded struct. Values are int64 (for all integer types), bool, float64, []byte, []any or thriftStruct.
+type thriftStruct map[int16]any
+
+func (s thriftStruct) int(id int16) (int64, bool) {
+	v, ok := s[id].(int64)
+	return v, ok
+}
+
+func (s thriftStruct) bool(id int16) (bool, bool) {
+	v, ok := s[id].(bool)
+	return v, ok
+}
+
+func (s thriftStruct) binary(id int16) []byte {
+	v, _ := s[id].([]byte)
+	return v
+}
+
+func (s thriftStruct) structField(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+func (s thriftStruct) list(id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+type thriftReader struct {
+	b   []byte
+	err error
+}
+
+func (r *thriftReader) fail() {
+	if r.err == nil {
+		r.err = errCorrupt
+	}
+	r.b = nil
+}
+
+func (r *thriftReader) byte() byte {
+	if len(r.b) == 0 {
+		r.fail()
+		return 0
+	}
+	b := r.b[0]
+	r.b = r.b[1:]
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// Compact protocol integers are zigzag encoded, the same as binary.Varint.
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) bytes(n uint64) []byte {
+	if n > uint64(len(r.b)) {
+		r.fail()
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) readStruct() thriftStruct {
+	s := thriftStruct{}
+	var lastId int16
+	for r.err == nil {
+		header := r.byte()
+		fieldType := header & 0x0f
+		if fieldType == thriftStop {
+			break
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			lastId += delta
+		} else {
+			lastId = int16(r.varint())
+		}
+
+		switch fieldType {
+		// Booleans in structs are stored in the field type.
+		case thriftTrue:
+			s[lastId] = true
+		case thriftFalse:
+			s[lastId] = false
+		default:
+			s[lastId] = r.readValue(fieldType)
+		}
+	}
+	return s
+}
+
+func (r *thriftReader) readValue(valueType byte) any {
+	switch valueType {
+	case thriftTrue, thriftFalse:
+		// Booleans in collections are a byte each.
+		return r.byte() == thriftTrue
+	case thriftByte:
+		return int64(int8(r.byte()))
+	case thriftI16, thriftI32, thriftI64:
+		return r.varint()
+	case thriftDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.bytes(8)))
+	case thriftBinary:
+		return r.bytes(r.uvarint())
+	case thriftList, thriftSet:
+		header := r.byte()
+		size := uint64(header >> 4)
+		if size == 15 {
+			size = r.uvarint()
+		}
+		// Every element takes at least a byte, which stops corrupt sizes from allocating a lot.
+		if size > uint64(len(r.b)) {
+			r.fail()
+			return nil
+		}
+		list := make([]any, 0, size)
+		for range size {
+			list = append(list, r.readValue(header&0x0f))
+		}
+		return list
+	case thriftMap:
+		// Parquet doesn't use maps, but we still have to skip over any we don't know about.
+		size := r.uvarint()
+		if size == 0 {
+			return nil
+		}
+		types := r.byte()
+		for range size {
+			r.readValue(types >> 4)
+			r.readValue(types & 0x0f)
+			if r.err != nil {
+				return nil
+			}
+		}
+		return nil
+	case thriftStructType:
+		return r.readStruct()
+	default:
+		r.fail()
+		return nil
+	}
+}
+
+type thriftWriter struct {
+	b []byte
+	// Field ids are written as a delta from the previous field in the same struct, so we keep a stack of them.
+	lastIds []int16
+}
+
+func (w *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	last := w.lastIds[len(w.lastIds)-1]
+	if id > last && id-last <= 15 {
+		w.b = append(w.b, byte(id-last)<<4|fieldType)
+	} else {
+		w.b = append(w.b, fieldType)
+		w.b = binary.AppendVarint(w.b, int64(id))
+	}
+	w.lastIds[len(w.lastIds)-1] = id
+}
+
+func (w *thriftWriter) structBegin() {
+	w.lastIds = append(w.lastIds, 0)
+}
+
+func (w *thriftWriter) structEnd() {
+	w.b = append(w.b, thriftStop)
+	w.lastIds = w.lastIds[:len(w.lastIds)-1]
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.b = binary.AppendVarint(w.b, int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.b = binary.AppendVarint(w.b, v)
+}
+
+func (w *thriftWriter) bool(id int16, v bool) {
+	if v {
+		w.fieldHeader(id, thriftTrue)
+	} else {
+		w.fieldHeader(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) binary(id int16, v []byte) {
+	w.fieldHeader(id, thriftBinary)
+	w.b = binary.AppendUvarint(w.b, uint64(len(v)))
+	w.b = append(w.b, v...)
+}
+
+// Must be followed by structBegin, the struct's fields and structEnd.
+func (w *thriftWriter) structField(id int16) {
+	w.fieldHeader(id, thriftStructType)
+}
+
+// Must be followed by `size` list elements.
+func (w *thriftWriter) listField(id int16, elementType byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.b = append(w.b, byte(size)<<4|elementType)
+	} else {
+		w.b = append(w.b, 0xf0|elementType)
+		w.b = binary.AppendUvarint(w.b, uint64(size))
+	}
+}
+
+func (w *thriftWriter) i32Element(v int32) {
+	w.b = binary.AppendVarint(w.b, int64(v))
+}
+
+func (w *thriftWriter) binaryElement(v []byte) {
+	w.b = binary.AppendUvarint(w.b, uint64(len(v)))
+	w.b = append(w.b, v...)
+}
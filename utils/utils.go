@@ -35,11 +35,16 @@ func Debug(a ...any) {
 	fmt.Println(args...)
 }
 
-// Because of JSON, all our numbers come back as floats. We are just going to assume everything is an int for now.
+// Because of JSON, all our numbers in untyped columns come back as floats. We are just going to assume everything is an
+// int for now. Typed int64 columns come back as int64s.
 func AsInt(x any) (int, error) {
 	switch v := x.(type) {
 	case int:
 		return v, nil
+	case int64:
+		return int(v), nil
+	case int32:
+		return int(v), nil
 	case float64:
 		return int(v), nil
 	default: